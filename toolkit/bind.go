@@ -0,0 +1,272 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BindTypeError is returned by Bind when a value cannot be converted to the
+// type of the field it is bound to. Source is query, form, header or path.
+type BindTypeError struct {
+	Source   string
+	Name     string
+	Value    string
+	Expected string
+	Err      error
+}
+
+func (e *BindTypeError) Error() string {
+	return fmt.Sprintf("%s parameter %q has incorrect type (expected %s, got %q)", e.Source, e.Name, e.Expected, e.Value)
+}
+
+func (e *BindTypeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BindTypeError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "type_mismatch", Path: e.Source + "." + e.Name, Expected: e.Expected, Actual: e.Value}
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Bind fills the fields of the struct pointed to by data from the request,
+// using struct tags to name the source of each field:
+//
+//	query:"page"        the URL query string
+//	form:"name"         url-encoded or multipart form values from the body
+//	header:"X-Trace-Id" request headers
+//	path:"id"           path parameters, looked up with PathParamFunc
+//
+// Strings, booleans, integers, floats, time.Duration, time.Time (RFC 3339, or
+// the layout in a time_format tag), encoding.TextUnmarshaler implementations,
+// pointers to these, and slices of them are supported. Slices take repeated
+// parameters or a single comma-separated one. Untagged struct fields are
+// bound recursively. Missing parameters leave fields untouched. When
+// ValidateJSON is set, the result is validated as ReadJson would.
+func (t *Tools) Bind(r *http.Request, data interface{}) error {
+	pointer := reflect.ValueOf(data)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() || pointer.Elem().Kind() != reflect.Struct {
+		return errors.New("bind target must be a non-nil pointer to a struct")
+	}
+
+	if err := t.parseBindForm(r); err != nil {
+		return err
+	}
+
+	if err := t.bindStruct(r, pointer.Elem()); err != nil {
+		return err
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+// parseBindForm parses url-encoded and multipart bodies so form tags can be
+// bound.
+func (t *Tools) parseBindForm(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		maxSize := t.MaxFileSize
+		if maxSize == 0 {
+			maxSize = 1024 * 1024 * 1024
+		}
+		if r.MultipartForm == nil {
+			if err := r.ParseMultipartForm(maxSize); err != nil {
+				return fmt.Errorf("body contains a badly-formed multipart form: %w", err)
+			}
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return fmt.Errorf("body contains a badly-formed form: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (t *Tools) bindStruct(r *http.Request, v reflect.Value) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		source, name := "", ""
+		for _, tag := range []string{"query", "form", "header", "path"} {
+			if value, ok := field.Tag.Lookup(tag); ok && value != "-" {
+				source, name = tag, value
+				break
+			}
+		}
+
+		if source == "" {
+			if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+				if err := t.bindStruct(r, v.Field(i)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		values := t.bindValues(r, source, name)
+		if len(values) == 0 {
+			continue
+		}
+
+		if err := setBindField(v.Field(i), values, field.Tag.Get("time_format")); err != nil {
+			return &BindTypeError{
+				Source:   source,
+				Name:     name,
+				Value:    strings.Join(values, ","),
+				Expected: bindTypeName(field.Type),
+				Err:      err,
+			}
+		}
+	}
+
+	return nil
+}
+
+// bindValues returns the raw values for a tagged field.
+func (t *Tools) bindValues(r *http.Request, source, name string) []string {
+	switch source {
+	case "query":
+		return r.URL.Query()[name]
+	case "form":
+		if r.MultipartForm != nil {
+			return r.MultipartForm.Value[name]
+		}
+		return r.PostForm[name]
+	case "header":
+		return r.Header.Values(name)
+	case "path":
+		if t.PathParamFunc == nil {
+			return nil
+		}
+		if value := t.PathParamFunc(r, name); value != "" {
+			return []string{value}
+		}
+	}
+
+	return nil
+}
+
+func setBindField(field reflect.Value, values []string, layout string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		if len(values) == 1 && strings.Contains(values[0], ",") {
+			values = strings.Split(values[0], ",")
+		}
+
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setBindValue(slice.Index(i), strings.TrimSpace(value), layout); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	return setBindValue(field, values[0], layout)
+}
+
+func setBindValue(field reflect.Value, value, layout string) error {
+	if field.Kind() == reflect.Ptr {
+		target := reflect.New(field.Type().Elem())
+		if err := setBindValue(target.Elem(), value, layout); err != nil {
+			return err
+		}
+		field.Set(target)
+		return nil
+	}
+
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) && field.Type() != timeType {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch {
+	case field.Type() == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(parsed))
+		return nil
+
+	case field.Type() == durationType:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// bindTypeName describes a field type for error messages.
+func bindTypeName(typ reflect.Type) string {
+	switch {
+	case typ == timeType:
+		return "time"
+	case typ == durationType:
+		return "duration"
+	case typ.Kind() == reflect.Ptr:
+		return bindTypeName(typ.Elem())
+	case typ.Kind() == reflect.Slice:
+		return "list of " + bindTypeName(typ.Elem())
+	}
+
+	return typ.Kind().String()
+}
@@ -0,0 +1,147 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTestPaging struct {
+	Page    int `query:"page"`
+	PerPage int `query:"per_page"`
+}
+
+type bindTestParams struct {
+	bindTestPaging
+	ID        int64         `path:"id"`
+	Search    *string       `query:"q"`
+	Active    bool          `query:"active"`
+	Tags      []string      `query:"tag"`
+	IDs       []int         `query:"ids"`
+	Since     time.Time     `query:"since"`
+	Day       time.Time     `query:"day" time_format:"2006-01-02"`
+	Timeout   time.Duration `query:"timeout"`
+	Ratio     float64       `query:"ratio"`
+	RequestID string        `header:"X-Request-Id"`
+	Name      string        `form:"name" validate:"required"`
+	Age       uint8         `form:"age"`
+}
+
+func bindPathParam(r *http.Request, name string) string {
+	if name == "id" {
+		return strings.TrimPrefix(r.URL.Path, "/users/")
+	}
+	return ""
+}
+
+func TestTools_BindURLEncoded(t *testing.T) {
+	testTools := Tools{PathParamFunc: bindPathParam}
+
+	query := "page=2&per_page=25&q=go&active=true&tag=a&tag=b&ids=1,2,3&since=2023-03-01T12:00:00Z&day=2023-03-02&timeout=1m30s&ratio=0.5"
+	request := httptest.NewRequest("POST", "/users/42?"+query, strings.NewReader(url.Values{"name": {"Jack"}, "age": {"30"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Request-Id", "abc")
+
+	var params bindTestParams
+	if err := testTools.Bind(request, &params); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDay := time.Date(2023, time.March, 2, 0, 0, 0, 0, time.UTC)
+	switch {
+	case params.Page != 2 || params.PerPage != 25:
+		t.Errorf("embedded struct not bound: %+v", params.bindTestPaging)
+	case params.ID != 42:
+		t.Errorf("expected path id 42 but got %d", params.ID)
+	case params.Search == nil || *params.Search != "go":
+		t.Errorf("expected q to be bound to a pointer")
+	case !params.Active || params.Ratio != 0.5 || params.Timeout != 90*time.Second:
+		t.Errorf("scalars not bound: %+v", params)
+	case !reflect.DeepEqual(params.Tags, []string{"a", "b"}) || !reflect.DeepEqual(params.IDs, []int{1, 2, 3}):
+		t.Errorf("slices not bound: %v %v", params.Tags, params.IDs)
+	case params.Since.Hour() != 12 || !params.Day.Equal(expectedDay):
+		t.Errorf("times not bound: %s %s", params.Since, params.Day)
+	case params.RequestID != "abc":
+		t.Errorf("header not bound: %q", params.RequestID)
+	case params.Name != "Jack" || params.Age != 30:
+		t.Errorf("form not bound: %q %d", params.Name, params.Age)
+	}
+}
+
+func TestTools_BindMultipart(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", "Jill")
+	_ = writer.WriteField("age", "41")
+	_ = writer.Close()
+
+	request := httptest.NewRequest("POST", "/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var testTools Tools
+	var params bindTestParams
+	if err := testTools.Bind(request, &params); err != nil {
+		t.Fatal(err)
+	}
+	if params.Name != "Jill" || params.Age != 41 {
+		t.Errorf("multipart form not bound: %q %d", params.Name, params.Age)
+	}
+}
+
+var bindErrorTests = []struct {
+	name     string
+	query    string
+	path     string
+	expected string
+}{
+	{name: "int", query: "page=two", path: "query.page", expected: "int"},
+	{name: "bool", query: "active=maybe", path: "query.active", expected: "bool"},
+	{name: "slice", query: "ids=1,x", path: "query.ids", expected: "list of int"},
+	{name: "time", query: "since=yesterday", path: "query.since", expected: "time"},
+	{name: "overflow", query: "page=1&per_page=99999999999999999999", path: "query.per_page", expected: "int"},
+}
+
+func TestTools_BindErrors(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range bindErrorTests {
+		request := httptest.NewRequest("GET", "/?"+e.query, nil)
+		var params bindTestParams
+		err := testTools.Bind(request, &params)
+
+		var bindError *BindTypeError
+		if !errors.As(err, &bindError) {
+			t.Errorf("%s: expected *BindTypeError but got %v", e.name, err)
+			continue
+		}
+
+		detail := bindError.ErrorData().(JSONErrorDetail)
+		if detail.Path != e.path || detail.Expected != e.expected || detail.Code != "type_mismatch" {
+			t.Errorf("%s: unexpected error detail %+v", e.name, detail)
+		}
+	}
+}
+
+func TestTools_BindValidates(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+	request := httptest.NewRequest("GET", "/?page=1", nil)
+
+	var params bindTestParams
+	err := testTools.Bind(request, &params)
+
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) || len(validationErrors["Name"]) == 0 {
+		t.Errorf("expected name to be required, got %v", err)
+	}
+
+	if err := testTools.Bind(request, params); err == nil {
+		t.Error("expected an error binding into a non-pointer")
+	}
+}
@@ -0,0 +1,242 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker for one host.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through while counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests until the cool-down has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through to decide whether
+	// the host has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOpenError is returned instead of sending a request while the
+// circuit for its host is open. ErrorJSON answers it with 503 Service
+// Unavailable.
+type CircuitOpenError struct {
+	Host string
+	// RetryAfter is how long remains until the circuit lets a trial
+	// request through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", e.Host)
+}
+
+func (e *CircuitOpenError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// CircuitBreaker stops JSONClient from calling hosts that keep failing. Each
+// host has its own circuit. Configure it through its fields before first use.
+type CircuitBreaker struct {
+	// FailureRate is the fraction of failed requests within Window that
+	// opens the circuit, once at least MinRequests have been made. They
+	// default to 0.5, 60 seconds and 10.
+	FailureRate float64
+	Window      time.Duration
+	MinRequests int
+	// CoolDown is how long an open circuit rejects requests before letting
+	// trial requests through. It defaults to 30 seconds.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of trial requests that must succeed to
+	// close the circuit again. It defaults to 1. A cancelled trial request
+	// counts as neither, and lets another trial through.
+	HalfOpenRequests int
+	// IsFailure reports whether a request failed. By default, transport
+	// errors other than cancellation and 5xx responses are failures.
+	IsFailure func(status int, err error) bool
+	// OnStateChange, when set, is called whenever a host's circuit changes
+	// state.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state CircuitState
+	// generation changes with every state change, so that results of
+	// requests let through in an earlier state are not counted.
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int
+	successes   int
+}
+
+// State returns the current state of the circuit for host.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.hosts[host]; ok {
+		if c.state == CircuitOpen && time.Since(c.openedAt) >= b.coolDown() {
+			return CircuitHalfOpen
+		}
+		return c.state
+	}
+
+	return CircuitClosed
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown == 0 {
+		return 30 * time.Second
+	}
+
+	return b.CoolDown
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests == 0 {
+		return 1
+	}
+
+	return b.HalfOpenRequests
+}
+
+// allow reports whether a request to host may be sent now and, if so, the
+// generation of the circuit to pass to record with its result.
+func (b *CircuitBreaker) allow(host string) (uint64, error) {
+	b.mu.Lock()
+	if b.hosts == nil {
+		b.hosts = map[string]*circuit{}
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.hosts[host] = c
+	}
+
+	var changed bool
+	if c.state == CircuitOpen {
+		remaining := b.coolDown() - time.Since(c.openedAt)
+		if remaining > 0 {
+			b.mu.Unlock()
+			return 0, &CircuitOpenError{Host: host, RetryAfter: remaining}
+		}
+		c.state, c.trials, c.successes, changed = CircuitHalfOpen, 0, 0, true
+		c.generation++
+	}
+
+	if c.state == CircuitHalfOpen {
+		if c.trials >= b.halfOpenRequests() {
+			b.mu.Unlock()
+			return 0, &CircuitOpenError{Host: host}
+		}
+		c.trials++
+	}
+	generation := c.generation
+	b.mu.Unlock()
+
+	if changed {
+		b.notify(host, CircuitOpen, CircuitHalfOpen)
+	}
+
+	return generation, nil
+}
+
+// record counts the outcome of a request to host that allow let through in
+// generation. Results from an earlier generation are ignored, and a
+// cancelled trial request frees its slot without counting either way.
+func (b *CircuitBreaker) record(host string, generation uint64, status int, err error) {
+	failed := b.failed(status, err)
+
+	b.mu.Lock()
+	c := b.hosts[host]
+	if c.generation != generation {
+		b.mu.Unlock()
+		return
+	}
+	from := c.state
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if errors.Is(err, context.Canceled) {
+			c.trials--
+		} else if failed {
+			c.state, c.openedAt = CircuitOpen, time.Now()
+		} else if c.successes++; c.successes >= b.halfOpenRequests() {
+			c.state, c.windowStart, c.requests, c.failures = CircuitClosed, time.Now(), 0, 0
+		}
+
+	case CircuitClosed:
+		window, minRequests, rate := b.Window, b.MinRequests, b.FailureRate
+		if window == 0 {
+			window = time.Minute
+		}
+		if minRequests == 0 {
+			minRequests = 10
+		}
+		if rate == 0 {
+			rate = 0.5
+		}
+
+		if time.Since(c.windowStart) > window {
+			c.windowStart, c.requests, c.failures = time.Now(), 0, 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= minRequests && float64(c.failures)/float64(c.requests) >= rate {
+			c.state, c.openedAt = CircuitOpen, time.Now()
+		}
+	}
+
+	to := c.state
+	if from != to {
+		c.generation++
+	}
+	b.mu.Unlock()
+
+	if from != to {
+		b.notify(host, from, to)
+	}
+}
+
+func (b *CircuitBreaker) failed(status int, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(status, err)
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	return status >= 500
+}
+
+func (b *CircuitBreaker) notify(host string, from, to CircuitState) {
+	if b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
@@ -0,0 +1,174 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONClient_CircuitBreaker(t *testing.T) {
+	var healthy int32
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var transitions []string
+	breaker := &CircuitBreaker{
+		FailureRate: 0.5,
+		MinRequests: 4,
+		CoolDown:    50 * time.Millisecond,
+		OnStateChange: func(host string, from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}
+
+	var testTools Tools
+	client := testTools.NewJSONClient(srv.URL)
+	client.CircuitBreaker = breaker
+	ctx := context.Background()
+	host := srv.Listener.Addr().String()
+
+	for i := 0; i < 4; i++ {
+		var remoteErr *RemoteError
+		if _, err := client.Get(ctx, "/", nil); !errors.As(err, &remoteErr) {
+			t.Fatalf("request %d: expected a RemoteError, got %v", i, err)
+		}
+	}
+	if breaker.State(host) != CircuitOpen {
+		t.Fatalf("expected the circuit to be open, got %s", breaker.State(host))
+	}
+
+	_, err := client.Get(ctx, "/", nil)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || openErr.Host != host || calls != 4 {
+		t.Fatalf("expected a CircuitOpenError without calling the host, got %v after %d calls", err, calls)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected ErrorJSON to answer 503, got %d", rr.Code)
+	}
+
+	// A failed trial request opens the circuit again.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Get(ctx, "/", nil); errors.As(err, &openErr) {
+		t.Fatal("expected a trial request after the cool-down")
+	}
+	if breaker.State(host) != CircuitOpen {
+		t.Fatalf("expected the circuit to reopen, got %s", breaker.State(host))
+	}
+
+	// A successful trial request closes it.
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Get(ctx, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if breaker.State(host) != CircuitClosed {
+		t.Fatalf("expected the circuit to close, got %s", breaker.State(host))
+	}
+
+	expected := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transitions %v, got %v", expected, transitions)
+			break
+		}
+	}
+}
+
+func TestCircuitBreaker_HalfOpenLimit(t *testing.T) {
+	breaker := &CircuitBreaker{MinRequests: 1, CoolDown: time.Millisecond, HalfOpenRequests: 2}
+	generation, err := breaker.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker.record("a", generation, 0, errors.New("connection refused"))
+
+	time.Sleep(5 * time.Millisecond)
+	var trials []uint64
+	for i := 0; i < 2; i++ {
+		generation, err := breaker.allow("a")
+		if err != nil {
+			t.Fatalf("trial %d rejected: %v", i, err)
+		}
+		trials = append(trials, generation)
+	}
+	if _, err := breaker.allow("a"); err == nil {
+		t.Error("expected requests beyond the trial limit to be rejected")
+	}
+	if _, err := breaker.allow("b"); err != nil {
+		t.Errorf("expected other hosts to be unaffected, got %v", err)
+	}
+
+	breaker.record("a", trials[0], http.StatusOK, nil)
+	breaker.record("a", trials[1], http.StatusOK, nil)
+	if breaker.State("a") != CircuitClosed {
+		t.Errorf("expected the circuit to close after the trials succeed, got %s", breaker.State("a"))
+	}
+}
+
+func TestCircuitBreaker_CancelledTrial(t *testing.T) {
+	breaker := &CircuitBreaker{MinRequests: 1, CoolDown: time.Millisecond}
+	generation, _ := breaker.allow("a")
+	breaker.record("a", generation, http.StatusBadGateway, nil)
+
+	time.Sleep(5 * time.Millisecond)
+	trial, err := breaker.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker.record("a", trial, 0, context.Canceled)
+	if breaker.State("a") != CircuitHalfOpen {
+		t.Errorf("expected a cancelled trial not to close the circuit, got %s", breaker.State("a"))
+	}
+
+	trial, err = breaker.allow("a")
+	if err != nil {
+		t.Fatalf("expected a cancelled trial to free its slot, got %v", err)
+	}
+	breaker.record("a", trial, http.StatusOK, nil)
+	if breaker.State("a") != CircuitClosed {
+		t.Errorf("expected a successful trial to close the circuit, got %s", breaker.State("a"))
+	}
+}
+
+func TestCircuitBreaker_LateResults(t *testing.T) {
+	breaker := &CircuitBreaker{MinRequests: 1, CoolDown: time.Millisecond}
+
+	// slow is let through while the circuit is closed and answers after it
+	// has opened and let a trial through.
+	slow, _ := breaker.allow("a")
+	failing, _ := breaker.allow("a")
+	breaker.record("a", failing, http.StatusBadGateway, nil)
+
+	time.Sleep(5 * time.Millisecond)
+	trial, err := breaker.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker.record("a", slow, http.StatusOK, nil)
+	if breaker.State("a") != CircuitHalfOpen {
+		t.Errorf("expected a result from before the circuit opened not to count as a trial, got %s", breaker.State("a"))
+	}
+
+	breaker.record("a", trial, http.StatusBadGateway, nil)
+	if breaker.State("a") != CircuitOpen {
+		t.Errorf("expected the failed trial to open the circuit, got %s", breaker.State("a"))
+	}
+}
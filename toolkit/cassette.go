@@ -0,0 +1,492 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// RoundTripFunc is an http.RoundTripper answering every request with the
+// function's response, for stubbing remote services in tests.
+type RoundTripFunc func(req *http.Request) *http.Response
+
+// RoundTrip calls f.
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// NewTestClient returns an http.Client whose requests are answered by fn.
+func NewTestClient(fn RoundTripFunc) *http.Client {
+	return &http.Client{
+		Transport: fn,
+	}
+}
+
+// CassetteMode controls whether a Cassette replays or records interactions.
+type CassetteMode int
+
+const (
+	// CassetteReplay answers requests from the cassette file only, and fails
+	// requests it has no recording for.
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord sends every request to the real server and records it,
+	// replacing the cassette's previous content.
+	CassetteRecord
+	// CassetteReplayOrRecord replays recorded requests and records the rest.
+	CassetteReplayOrRecord
+)
+
+// CassetteRequest is a recorded request.
+type CassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not valid UTF-8.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// BodyBytes returns the recorded body.
+func (r CassetteRequest) BodyBytes() []byte {
+	return decodeCassetteBody(r.Body, r.BodyEncoding)
+}
+
+// CassetteResponse is a recorded response.
+type CassetteResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not valid UTF-8.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// BodyBytes returns the recorded body.
+func (r CassetteResponse) BodyBytes() []byte {
+	return decodeCassetteBody(r.Body, r.BodyEncoding)
+}
+
+// encodeCassetteBody returns body as stored in a cassette, and its encoding.
+func encodeCassetteBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeCassetteBody(body, encoding string) []byte {
+	if encoding == "base64" {
+		if data, err := base64.StdEncoding.DecodeString(body); err == nil {
+			return data
+		}
+	}
+
+	return []byte(body)
+}
+
+// CassetteInteraction is a recorded request and its response.
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteMatcher reports whether the request r, with body, matches a
+// recorded request.
+type CassetteMatcher func(r *http.Request, body []byte, recorded CassetteRequest) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(r *http.Request, body []byte, recorded CassetteRequest) bool {
+	return r.Method == recorded.Method
+}
+
+// MatchURL matches requests with the same URL, including the query, whose
+// redacted parameters match whatever their value.
+func MatchURL(r *http.Request, body []byte, recorded CassetteRequest) bool {
+	return r.URL.String() == recorded.URL
+}
+
+// MatchPath matches requests with the same scheme, host and path, ignoring
+// the query.
+func MatchPath(r *http.Request, body []byte, recorded CassetteRequest) bool {
+	u := *r.URL
+	u.RawQuery = ""
+	return u.String() == strings.SplitN(recorded.URL, "?", 2)[0]
+}
+
+// MatchBody matches requests with the same body. JSON bodies match if they
+// are equivalent, whatever their formatting and key order. Redacted fields
+// match whatever their value.
+func MatchBody(r *http.Request, body []byte, recorded CassetteRequest) bool {
+	recordedBody := recorded.BodyBytes()
+	var a, b interface{}
+	if json.Unmarshal(body, &a) == nil && json.Unmarshal(recordedBody, &b) == nil {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return bytes.Equal(body, recordedBody)
+}
+
+// MatchHeader returns a matcher for requests with the same values for the
+// named headers. Redacted headers never match.
+func MatchHeader(names ...string) CassetteMatcher {
+	return func(r *http.Request, body []byte, recorded CassetteRequest) bool {
+		for _, name := range names {
+			if strings.Join(r.Header.Values(name), ",") != strings.Join(recorded.Header.Values(name), ",") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// CassetteMissError is returned when a replaying Cassette has no recording
+// for a request.
+type CassetteMissError struct {
+	Method string
+	URL    string
+}
+
+func (e *CassetteMissError) Error() string {
+	return fmt.Sprintf("cassette has no recorded response for %s %s", e.Method, e.URL)
+}
+
+// cassetteRedacted replaces the value of redacted headers.
+const cassetteRedacted = "REDACTED"
+
+// Cassette is an http.RoundTripper that records request/response pairs to a
+// JSON file and replays them, so that tests of code calling remote services
+// run deterministically and offline. Recorded interactions are replayed in
+// order; each is used once. Configure it through its fields before first
+// use.
+type Cassette struct {
+	// Path is the cassette file.
+	Path string
+	Mode CassetteMode
+	// Transport sends requests when recording. It defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+	// Matchers decide which recording answers a request. They default to
+	// MatchMethod, MatchURL and MatchBody.
+	Matchers []CassetteMatcher
+	// RedactHeaders are recorded as REDACTED, in requests and responses. They
+	// default to Authorization, Proxy-Authorization, Cookie, Set-Cookie and
+	// X-Api-Key.
+	RedactHeaders []string
+	// RedactFields are recorded as REDACTED wherever they appear as query
+	// parameters, form fields or JSON object keys, at any depth, in request
+	// and response bodies. They default to access_token, refresh_token,
+	// id_token, client_secret, password and api_key. Matching compares the
+	// redacted request with the recording.
+	RedactFields []string
+
+	mu           sync.Mutex
+	interactions []CassetteInteraction
+	used         []bool
+}
+
+// NewCassette returns a cassette using the file at path. In replay modes
+// the file is loaded; it must exist for CassetteReplay.
+func (t *Tools) NewCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode}
+	if mode == CassetteRecord {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && mode == CassetteReplayOrRecord {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Interactions []CassetteInteraction `json:"interactions"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	c.interactions = file.Interactions
+	c.used = make([]bool, len(file.Interactions))
+
+	return c, nil
+}
+
+// Client returns an http.Client using the cassette as its transport.
+func (c *Cassette) Client() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+// Interactions returns the recorded interactions.
+func (c *Cassette) Interactions() []CassetteInteraction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]CassetteInteraction(nil), c.interactions...)
+}
+
+// RoundTrip answers req from the cassette or, when recording, from the real
+// server.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// Recordings hold the redacted request, so compare them with that.
+	redacted := req.Clone(req.Context())
+	redacted.URL = c.redactURL(req.URL)
+	redactedBody := c.redactBody(req.Header, body)
+
+	if c.Mode != CassetteRecord {
+		if recorded, ok := c.find(redacted, redactedBody); ok {
+			return recorded.response(req), nil
+		}
+		if c.Mode == CassetteReplay {
+			return nil, &CassetteMissError{Method: req.Method, URL: redacted.URL.String()}
+		}
+	}
+
+	return c.record(req, redacted, redactedBody)
+}
+
+// find returns the first unused recording matching req, which is redacted.
+func (c *Cassette) find(req *http.Request, body []byte) (CassetteResponse, bool) {
+	matchers := c.Matchers
+	if matchers == nil {
+		matchers = []CassetteMatcher{MatchMethod, MatchURL, MatchBody}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+next:
+	for i, interaction := range c.interactions {
+		if c.used[i] {
+			continue
+		}
+		for _, match := range matchers {
+			if !match(req, body, interaction.Request) {
+				continue next
+			}
+		}
+		c.used[i] = true
+		return interaction.Response, true
+	}
+
+	return CassetteResponse{}, false
+}
+
+// record sends req and records it, as redacted, with its response.
+func (c *Cassette) record(req, redacted *http.Request, redactedBody []byte) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	response, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := CassetteInteraction{
+		Request: CassetteRequest{
+			Method: req.Method,
+			URL:    redacted.URL.String(),
+			Header: c.redact(req.Header),
+		},
+		Response: CassetteResponse{
+			Status: response.StatusCode,
+			Header: c.redact(response.Header),
+		},
+	}
+	interaction.Request.Body, interaction.Request.BodyEncoding = encodeCassetteBody(redactedBody)
+	interaction.Response.Body, interaction.Response.BodyEncoding = encodeCassetteBody(c.redactBody(response.Header, responseBody))
+
+	c.mu.Lock()
+	c.interactions = append(c.interactions, interaction)
+	c.used = append(c.used, true)
+	err = c.save()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+	return response, nil
+}
+
+func (c *Cassette) redact(header http.Header) http.Header {
+	names := c.RedactHeaders
+	if names == nil {
+		names = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	}
+
+	header = header.Clone()
+	for _, name := range names {
+		if values := header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = []string{cassetteRedacted}
+		}
+	}
+
+	return header
+}
+
+func (c *Cassette) redactFields() map[string]bool {
+	names := c.RedactFields
+	if names == nil {
+		names = []string{"access_token", "refresh_token", "id_token", "client_secret", "password", "api_key"}
+	}
+
+	fields := make(map[string]bool, len(names))
+	for _, name := range names {
+		fields[name] = true
+	}
+
+	return fields
+}
+
+// redactURL returns a copy of u with the redacted query parameters replaced.
+func (c *Cassette) redactURL(u *url.URL) *url.URL {
+	redacted := *u
+	query := u.Query()
+	if redactValues(query, c.redactFields()) {
+		redacted.RawQuery = query.Encode()
+	}
+
+	return &redacted
+}
+
+// redactBody replaces the redacted fields of a form or JSON body. Other
+// bodies, and bodies that do not parse, are returned unchanged.
+func (c *Cassette) redactBody(header http.Header, body []byte) []byte {
+	fields := c.redactFields()
+	if len(body) == 0 || len(fields) == 0 {
+		return body
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil || !redactValues(values, fields) {
+			return body
+		}
+		return []byte(values.Encode())
+
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if decoder.Decode(&value) != nil || !redactJSONValue(value, fields) {
+			return body
+		}
+		if out, err := json.Marshal(value); err == nil {
+			return out
+		}
+	}
+
+	return body
+}
+
+// redactValues replaces the values of the redacted fields and reports
+// whether there were any.
+func redactValues(values url.Values, fields map[string]bool) bool {
+	changed := false
+	for name := range values {
+		if fields[name] {
+			values[name] = []string{cassetteRedacted}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// redactJSONValue replaces the values of redacted keys in the objects within
+// value and reports whether there were any.
+func redactJSONValue(value interface{}, fields map[string]bool) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, member := range v {
+			if fields[key] {
+				v[key] = cassetteRedacted
+				changed = true
+				continue
+			}
+			if redactJSONValue(member, fields) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if redactJSONValue(item, fields) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// save writes the cassette file. The caller must hold c.mu.
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(map[string]interface{}{"interactions": c.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.Path, append(data, '\n'), 0644)
+}
+
+func (r CassetteResponse) response(req *http.Request) *http.Response {
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	body := r.BodyBytes()
+	if header.Get("Content-Length") != "" {
+		// Redaction may have changed the body's length.
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
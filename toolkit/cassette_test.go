@@ -0,0 +1,197 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette_RecordAndReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `","echo":` + string(body) + `}`))
+	}))
+
+	var testTools Tools
+	path := filepath.Join(t.TempDir(), "fixtures", "orders.json")
+	recorder, err := testTools.NewCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := testTools.NewJSONClient(srv.URL)
+	client.HTTPClient = recorder.Client()
+	client.Headers.Set("Authorization", "Bearer secret-token")
+
+	var first map[string]interface{}
+	if _, err := client.Post(context.Background(), "/orders", map[string]interface{}{"id": 1, "qty": 2}, &first); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || !strings.Contains(string(data), cassetteRedacted) {
+		t.Errorf("expected auth headers to be redacted, got %s", data)
+	}
+
+	player, err := testTools.NewCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.HTTPClient = player.Client()
+
+	// Equivalent JSON matches whatever its key order.
+	var replayed map[string]interface{}
+	response, err := client.Post(context.Background(), "/orders", map[string]interface{}{"qty": 2, "id": 1}, &replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed["path"] != "/orders" || response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected replayed response %v %v", replayed, response.Header)
+	}
+
+	// Each recording is used once.
+	_, err = client.Post(context.Background(), "/orders", map[string]interface{}{"id": 1, "qty": 2}, nil)
+	var miss *CassetteMissError
+	if !errors.As(err, &miss) || miss.Method != http.MethodPost {
+		t.Errorf("expected a CassetteMissError, got %v", err)
+	}
+}
+
+func TestCassette_Matchers(t *testing.T) {
+	calls := 0
+	var testTools Tools
+	cassette, err := testTools.NewCassette(filepath.Join(t.TempDir(), "c.json"), CassetteReplayOrRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`"ok"`))}
+	})
+	cassette.Matchers = []CassetteMatcher{MatchMethod, MatchPath}
+
+	client := cassette.Client()
+	for _, uri := range []string{"http://example.com/a?page=1", "http://example.com/b"} {
+		response, err := client.Get(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	replay, err := testTools.NewCassette(cassette.Path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Matchers = []CassetteMatcher{MatchMethod, MatchPath}
+
+	var tests = []struct {
+		name     string
+		uri      string
+		expectOK bool
+	}{
+		{"different query", "http://example.com/a?page=2", true},
+		{"other path", "http://example.com/b", true},
+		{"unrecorded path", "http://example.com/c", false},
+	}
+
+	for _, e := range tests {
+		response, err := replay.Client().Get(e.uri)
+		if err == nil {
+			response.Body.Close()
+		}
+		if (err == nil) != e.expectOK {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+	}
+
+	if calls != 2 || len(replay.Interactions()) != 2 {
+		t.Errorf("expected 2 recorded calls, got %d", calls)
+	}
+}
+
+func TestCassette_RedactBodiesAndQuery(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(binary)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"secret-access","token_type":"Bearer","scopes":[{"id_token":"secret-id"}]}`))
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	path := filepath.Join(t.TempDir(), "oauth.json")
+	recorder, err := testTools.NewCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := func(secret string) url.Values {
+		return url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {secret}}
+	}
+	response, err := recorder.Client().PostForm(srv.URL+"/token?api_key=secret-key&page=1", form("secret-client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	response, err = recorder.Client().Get(srv.URL + "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-") {
+		t.Errorf("expected bodies and query to be redacted, got %s", data)
+	}
+	if !strings.Contains(string(data), `"body_encoding": "base64"`) {
+		t.Errorf("expected the binary body to be stored as base64, got %s", data)
+	}
+
+	player, err := testTools.NewCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The live request is redacted before matching, whatever its secrets.
+	response, err = player.Client().PostForm(srv.URL+"/token?api_key=other-key&page=1", form("other-client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), `"access_token":"REDACTED"`) || !strings.Contains(string(body), `"token_type":"Bearer"`) {
+		t.Errorf("unexpected replayed token response %s", body)
+	}
+
+	response, err = player.Client().Get(srv.URL + "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if !bytes.Equal(body, binary) {
+		t.Errorf("expected the binary body to be replayed intact, got %v", body)
+	}
+}
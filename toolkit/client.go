@@ -0,0 +1,362 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RemoteError is returned by JSONClient when the remote service answers with
+// a status outside the 2xx range. Body holds the raw response body.
+type RemoteError struct {
+	Method string
+	URL    string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Attempts is the number of requests made before giving up.
+	Attempts int
+}
+
+func (e *RemoteError) Error() string {
+	body := strings.TrimSpace(string(e.Body))
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	if body == "" {
+		return fmt.Sprintf("%s %s: remote service responded with status %d", e.Method, e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s %s: remote service responded with status %d: %s", e.Method, e.URL, e.Status, body)
+}
+
+// Decode unmarshals the error body into v.
+func (e *RemoteError) Decode(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+// ResponseTooLargeError is returned when a response body is larger than
+// JSONClient.MaxResponseSize.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body must not be larger than %d bytes", e.Limit)
+}
+
+// RequestOptions adjusts a single JSONClient call.
+type RequestOptions struct {
+	// Headers are added to the client's default headers, replacing any
+	// with the same name.
+	Headers http.Header
+	// Query is added to the request URL.
+	Query url.Values
+	// Error, when set, receives the decoded body of a non-2xx response in
+	// addition to the *RemoteError returned.
+	Error interface{}
+	// IdempotencyKey is sent in the retry policy's idempotency header and
+	// allows requests with non-idempotent methods to be retried.
+	IdempotencyKey string
+	// Attempts, when set, receives the number of attempts made.
+	Attempts *int
+}
+
+// JSONClient calls remote JSON APIs. Configure it through its fields before
+// first use; a JSONClient is safe for concurrent use afterwards.
+type JSONClient struct {
+	// BaseURL is prepended to request paths that are not absolute URLs.
+	BaseURL string
+	// Headers are sent with every request.
+	Headers http.Header
+	// Timeout bounds each call, including reading the response. It
+	// defaults to 30 seconds; a negative value disables it.
+	Timeout time.Duration
+	// MaxResponseSize defaults to 10MB.
+	MaxResponseSize int64
+	// HTTPClient sends the requests. It defaults to a client without a
+	// timeout of its own, as Timeout applies.
+	HTTPClient *http.Client
+	// Retry, when set, retries failed requests. Timeout covers all attempts.
+	Retry *RetryPolicy
+	// CircuitBreaker, when set, fails requests to hosts that keep failing
+	// with a *CircuitOpenError instead of waiting on them.
+	CircuitBreaker *CircuitBreaker
+	// Signer, when set, signs every request as a webhook.
+	Signer *WebhookSigner
+	// RateLimiter, when set, limits the rate and concurrency of requests.
+	RateLimiter *RateLimiter
+	// TokenSource, when set, supplies a bearer token for every request. A
+	// request answered with 401 is sent once more with a new token.
+	TokenSource TokenSource
+}
+
+// NewJSONClient returns a client for the API at baseURL.
+func (t *Tools) NewJSONClient(baseURL string) *JSONClient {
+	return &JSONClient{BaseURL: baseURL, Headers: make(http.Header)}
+}
+
+// Get sends a GET request and decodes the response into result, which may be
+// nil.
+func (c *JSONClient) Get(ctx context.Context, path string, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	return c.Do(ctx, http.MethodGet, path, nil, result, opts...)
+}
+
+// Post sends body as JSON and decodes the response into result.
+func (c *JSONClient) Post(ctx context.Context, path string, body, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPost, path, body, result, opts...)
+}
+
+// Put sends body as JSON and decodes the response into result.
+func (c *JSONClient) Put(ctx context.Context, path string, body, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPut, path, body, result, opts...)
+}
+
+// Patch sends body as JSON and decodes the response into result.
+func (c *JSONClient) Patch(ctx context.Context, path string, body, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPatch, path, body, result, opts...)
+}
+
+// Delete sends a DELETE request and decodes the response into result.
+func (c *JSONClient) Delete(ctx context.Context, path string, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	return c.Do(ctx, http.MethodDelete, path, nil, result, opts...)
+}
+
+// Do sends a request with body, if not nil, marshalled as JSON. A 2xx
+// response is decoded into result, if not nil; any other status is returned
+// as a *RemoteError. The returned response's body has already been read and
+// closed, but it can still be read again by the caller.
+func (c *JSONClient) Do(ctx context.Context, method, path string, body, result interface{}, opts ...RequestOptions) (*http.Response, error) {
+	var options RequestOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	attempts := 0
+	if options.Attempts == nil {
+		options.Attempts = &attempts
+	}
+
+	response, data, err := c.send(ctx, method, path, body, options)
+	if err != nil {
+		return response, err
+	}
+
+	// The URL send requested; not every transport sets response.Request.
+	location, _ := c.resolveURL(path, options.Query)
+	if parsed, err := url.Parse(location); err == nil {
+		location = parsed.Redacted()
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		remoteErr := &RemoteError{
+			Method:   method,
+			URL:      location,
+			Status:   response.StatusCode,
+			Header:   response.Header,
+			Body:     data,
+			Attempts: *options.Attempts,
+		}
+		if options.Error != nil && len(bytes.TrimSpace(data)) > 0 {
+			_ = json.Unmarshal(data, options.Error)
+		}
+		return response, remoteErr
+	}
+
+	if result != nil && len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return response, fmt.Errorf("error decoding response from %s: %w", location, err)
+		}
+	}
+
+	return response, nil
+}
+
+// send performs the exchange without interpreting the status code.
+func (c *JSONClient) send(ctx context.Context, method, path string, body interface{}, options RequestOptions) (*http.Response, []byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if options.Attempts == nil {
+		options.Attempts = new(int)
+	}
+
+	uri, err := c.resolveURL(path, options.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var payload []byte
+	if body != nil {
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var token *Token
+	newRequest := func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, method, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range c.Headers {
+			request.Header[key] = append([]string(nil), values...)
+		}
+		for key, values := range options.Headers {
+			request.Header[key] = append([]string(nil), values...)
+		}
+		if options.IdempotencyKey != "" {
+			request.Header.Set(c.Retry.idempotencyHeader(), options.IdempotencyKey)
+		}
+		if request.Header.Get("Accept") == "" {
+			request.Header.Set("Accept", "application/json")
+		}
+		if payload != nil {
+			if request.Header.Get("Content-Type") == "" {
+				request.Header.Set("Content-Type", "application/json")
+			}
+			request.ContentLength = int64(len(payload))
+			request.Body = io.NopCloser(bytes.NewReader(payload))
+			request.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(payload)), nil
+			}
+		}
+		if c.TokenSource != nil {
+			if token, err = c.authorize(request); err != nil {
+				return nil, err
+			}
+		}
+		if c.Signer != nil {
+			if err := c.Signer.Sign(request, payload); err != nil {
+				return nil, err
+			}
+		}
+		return request, nil
+	}
+
+	response, data, err := c.sendWithRetries(ctx, newRequest, options)
+	if err != nil || response.StatusCode != http.StatusUnauthorized || c.TokenSource == nil {
+		return response, data, err
+	}
+
+	// The token may have been revoked before it expired; get a new one and
+	// try once more.
+	c.TokenSource.Invalidate(token)
+	attempts := *options.Attempts
+	response, data, err = c.sendWithRetries(ctx, newRequest, options)
+	*options.Attempts += attempts
+
+	return response, data, err
+}
+
+// attempt sends one request through the rate limiter and circuit breaker,
+// if any.
+func (c *JSONClient) attempt(request *http.Request) (*http.Response, []byte, error) {
+	if c.RateLimiter != nil {
+		release, err := c.RateLimiter.acquire(request.Context(), request)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer release()
+	}
+
+	host := request.URL.Host
+	var generation uint64
+	if c.CircuitBreaker != nil {
+		var err error
+		if generation, err = c.CircuitBreaker.allow(host); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	response, data, err := c.roundTrip(request)
+
+	if c.CircuitBreaker != nil {
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+		}
+		c.CircuitBreaker.record(host, generation, status, err)
+	}
+	if c.RateLimiter != nil && response != nil {
+		c.RateLimiter.observe(request, response)
+	}
+
+	return response, data, err
+}
+
+// roundTrip sends request and reads the whole response body, leaving a copy
+// in the response for the caller.
+func (c *JSONClient) roundTrip(request *http.Request) (*http.Response, []byte, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	maxSize := c.MaxResponseSize
+	if maxSize == 0 {
+		maxSize = 10 * 1024 * 1024
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, nil, &ResponseTooLargeError{Limit: maxSize}
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	return response, data, nil
+}
+
+// resolveURL joins path onto BaseURL, unless path is already absolute, and
+// adds query.
+func (c *JSONClient) resolveURL(path string, query url.Values) (string, error) {
+	uri := path
+	if c.BaseURL != "" && !strings.Contains(path, "://") {
+		uri = strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+		if path == "" {
+			uri = c.BaseURL
+		}
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", errors.New("request URL must be absolute; set BaseURL or pass a full URL")
+	}
+
+	if len(query) > 0 {
+		values := parsed.Query()
+		for key, list := range query {
+			values[key] = append(values[key], list...)
+		}
+		parsed.RawQuery = values.Encode()
+	}
+
+	return parsed.String(), nil
+}
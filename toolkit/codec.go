@@ -0,0 +1,259 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Codec marshals and unmarshals bodies of one media type. JSON and XML codecs
+// are built in; others, such as MessagePack or CBOR, can be added with
+// RegisterCodec.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return "application/json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string                        { return "application/xml" }
+func (xmlCodec) Marshal(v interface{}) ([]byte, error)      { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// UnsupportedMediaTypeError is returned by Read when no codec handles the
+// request's Content-Type.
+type UnsupportedMediaTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("content type %q is not supported", e.ContentType)
+}
+
+func (e *UnsupportedMediaTypeError) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// NotAcceptableError is returned by Write when no codec satisfies the request's
+// Accept header.
+type NotAcceptableError struct {
+	Accept string
+}
+
+func (e *NotAcceptableError) Error() string {
+	return fmt.Sprintf("none of the accepted content types %q can be produced", e.Accept)
+}
+
+func (e *NotAcceptableError) StatusCode() int {
+	return http.StatusNotAcceptable
+}
+
+// RegisterCodec adds a codec for Read and Write, replacing any codec already
+// registered for the same content type. Register codecs before serving
+// requests.
+func (t *Tools) RegisterCodec(codec Codec) {
+	for i, c := range t.codecs {
+		if strings.EqualFold(c.ContentType(), codec.ContentType()) {
+			t.codecs[i] = codec
+			return
+		}
+	}
+
+	t.codecs = append(t.codecs, codec)
+}
+
+// availableCodecs lists JSON and XML, or the codecs registered in their
+// place, followed by the other registered codecs.
+func (t *Tools) availableCodecs() []Codec {
+	var codecs []Codec
+	for _, builtin := range []Codec{jsonCodec{}, xmlCodec{}} {
+		if registered := codecFor(builtin.ContentType(), t.codecs); registered != nil {
+			codecs = append(codecs, registered)
+			continue
+		}
+		codecs = append(codecs, builtin)
+	}
+
+	for _, codec := range t.codecs {
+		if codecFor(codec.ContentType(), codecs) == nil {
+			codecs = append(codecs, codec)
+		}
+	}
+
+	return codecs
+}
+
+func codecFor(mediaType string, codecs []Codec) Codec {
+	for _, codec := range codecs {
+		if strings.EqualFold(codec.ContentType(), mediaType) {
+			return codec
+		}
+	}
+
+	return nil
+}
+
+// isJSONMediaType reports whether mediaType is application/json or uses the
+// +json structured syntax suffix.
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Read decodes the request body into data using the codec matching its
+// Content-Type. JSON bodies, and bodies without a Content-Type, go through
+// ReadJson and so follow all of its rules; other codecs share the MaxJSONSize
+// limit and ValidateJSON setting. An unsupported Content-Type returns an
+// *UnsupportedMediaTypeError, which ErrorJSON sends as 415.
+func (t *Tools) Read(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return t.ReadJson(w, r, data)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return &UnsupportedMediaTypeError{ContentType: contentType}
+	}
+
+	codecs := t.availableCodecs()
+	codec := codecFor(mediaType, codecs)
+	if codec == nil && isJSONMediaType(mediaType) {
+		codec = codecFor("application/json", codecs)
+	}
+	if codec == nil {
+		return &UnsupportedMediaTypeError{ContentType: mediaType}
+	}
+	if _, ok := codec.(jsonCodec); ok {
+		return t.ReadJson(w, r, data)
+	}
+
+	body, err := t.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	if len(body) == 0 {
+		return &JSONEmptyBodyError{}
+	}
+
+	err = codec.Unmarshal(body, data)
+	if err != nil {
+		return fmt.Errorf("body contains badly-formed %s: %w", mediaType, err)
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+// Write encodes data with the codec that best matches the request's Accept
+// header and writes it with the given status. JSON is used when the request
+// has no Accept header. If nothing acceptable can be produced, Write returns a
+// *NotAcceptableError without writing anything, for the caller to pass to
+// ErrorJSON, which answers it with 406. Data holding fields tagged redact is
+// only written as JSON; other codecs return an error.
+func (t *Tools) Write(w http.ResponseWriter, r *http.Request, status int, data interface{}, opts ...WriteOptions) error {
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	codec := negotiateCodec(accept, t.availableCodecs())
+	if codec == nil {
+		return &NotAcceptableError{Accept: accept}
+	}
+
+	var out []byte
+	var err error
+	if _, ok := codec.(jsonCodec); ok {
+		out, err = t.marshalJSON(data)
+	} else if holdsRedacted(reflect.ValueOf(data), map[uintptr]bool{}) {
+		err = fmt.Errorf("%s codec cannot leave out the redacted fields of %T", codec.ContentType(), data)
+	} else {
+		out, err = codec.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	options := writeOptions(opts)
+	options.Request = r
+
+	return t.writeBody(w, status, out, codec.ContentType(), options)
+}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateCodec picks the codec with the highest quality in accept, preferring
+// more specific media ranges and then the order of codecs.
+func negotiateCodec(accept string, codecs []Codec) Codec {
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0]
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+
+	var best Codec
+	bestQuality, bestSpecificity := 0.0, -1
+	for _, codec := range codecs {
+		quality, specificity := matchAcceptRanges(codec.ContentType(), ranges)
+		if quality > bestQuality || (quality == bestQuality && quality > 0 && specificity > bestSpecificity) {
+			best, bestQuality, bestSpecificity = codec, quality, specificity
+		}
+	}
+
+	return best
+}
+
+// matchAcceptRanges returns the quality of the most specific range matching
+// contentType, and how specific that range was.
+func matchAcceptRanges(contentType string, ranges []acceptRange) (float64, int) {
+	contentType = strings.ToLower(contentType)
+	mainType, _, _ := strings.Cut(contentType, "/")
+	quality, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == contentType:
+			s = 2
+		case ar.mediaType == mainType+"/*":
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			quality, specificity = ar.quality, s
+		}
+	}
+
+	return quality, specificity
+}
@@ -0,0 +1,148 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pairCodec is a stand-in for codecs such as MessagePack, encoding a
+// codecTestPayload as "foo=<value>".
+type pairCodec struct{}
+
+func (pairCodec) ContentType() string { return "application/x-pair" }
+
+func (pairCodec) Marshal(v interface{}) ([]byte, error) {
+	payload, ok := v.(codecTestPayload)
+	if !ok {
+		return nil, errors.New("unsupported value")
+	}
+	return []byte("foo=" + payload.Foo), nil
+}
+
+func (pairCodec) Unmarshal(data []byte, v interface{}) error {
+	payload, ok := v.(*codecTestPayload)
+	if !ok || !strings.HasPrefix(string(data), "foo=") {
+		return errors.New("malformed pair")
+	}
+	payload.Foo = strings.TrimPrefix(string(data), "foo=")
+	return nil
+}
+
+type codecTestPayload struct {
+	XMLName xml.Name `json:"-" xml:"payload"`
+	Foo     string   `json:"foo" xml:"foo"`
+}
+
+var readCodecTests = []struct {
+	name          string
+	contentType   string
+	body          string
+	expected      string
+	errorExpected bool
+	status        int
+}{
+	{name: "no content type", body: `{"foo": "bar"}`, expected: "bar"},
+	{name: "json", contentType: "application/json; charset=utf-8", body: `{"foo": "bar"}`, expected: "bar"},
+	{name: "json suffix", contentType: "application/vnd.api+json", body: `{"foo": "bar"}`, expected: "bar"},
+	{name: "xml", contentType: "application/xml", body: `<payload><foo>bar</foo></payload>`, expected: "bar"},
+	{name: "registered", contentType: "application/x-pair", body: `foo=bar`, expected: "bar"},
+	{name: "bad xml", contentType: "application/xml", body: `<payload>`, errorExpected: true, status: http.StatusBadRequest},
+	{name: "unsupported", contentType: "text/csv", body: `foo,bar`, errorExpected: true, status: http.StatusUnsupportedMediaType},
+}
+
+func TestTools_Read(t *testing.T) {
+	var testTools Tools
+	testTools.RegisterCodec(pairCodec{})
+
+	for _, e := range readCodecTests {
+		request := httptest.NewRequest("POST", "/", strings.NewReader(e.body))
+		if e.contentType != "" {
+			request.Header.Set("Content-Type", e.contentType)
+		}
+
+		var payload codecTestPayload
+		err := testTools.Read(httptest.NewRecorder(), request, &payload)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: error expected, but none received", e.name)
+				continue
+			}
+			responseRecorder := httptest.NewRecorder()
+			_ = testTools.ErrorJSON(responseRecorder, err)
+			if responseRecorder.Code != e.status {
+				t.Errorf("%s: expected status %d but got %d", e.name, e.status, responseRecorder.Code)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+		}
+		if payload.Foo != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, payload.Foo)
+		}
+	}
+}
+
+var writeCodecTests = []struct {
+	name        string
+	accept      string
+	status      int
+	contentType string
+	body        string
+}{
+	{name: "no accept", status: http.StatusOK, contentType: "application/json", body: `{"foo":"bar"}`},
+	{name: "any", accept: "*/*", status: http.StatusOK, contentType: "application/json", body: `{"foo":"bar"}`},
+	{name: "xml", accept: "application/xml", status: http.StatusOK, contentType: "application/xml",
+		body: `<payload><foo>bar</foo></payload>`},
+	{name: "quality", accept: "application/json;q=0.5, application/x-pair", status: http.StatusOK,
+		contentType: "application/x-pair", body: `foo=bar`},
+	{name: "wildcard subtype", accept: "text/html, application/*;q=0.8", status: http.StatusOK,
+		contentType: "application/json", body: `{"foo":"bar"}`},
+	{name: "not acceptable", accept: "text/html", status: http.StatusNotAcceptable, contentType: "application/json"},
+}
+
+func TestTools_Write(t *testing.T) {
+	var testTools Tools
+	testTools.RegisterCodec(pairCodec{})
+
+	for _, e := range writeCodecTests {
+		request := httptest.NewRequest("GET", "/", nil)
+		if e.accept != "" {
+			request.Header.Set("Accept", e.accept)
+		}
+
+		responseRecorder := httptest.NewRecorder()
+		err := testTools.Write(responseRecorder, request, http.StatusOK, codecTestPayload{Foo: "bar"})
+
+		var notAcceptable *NotAcceptableError
+		if e.status == http.StatusNotAcceptable && !errors.As(err, &notAcceptable) {
+			t.Errorf("%s: expected *NotAcceptableError but got %v", e.name, err)
+		}
+		if e.status == http.StatusOK && err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+		}
+		if err != nil {
+			// Errors are left for the caller to answer.
+			if responseRecorder.Body.Len() != 0 {
+				t.Errorf("%s: expected nothing to be written with the error, got %s", e.name, responseRecorder.Body)
+			}
+			_ = testTools.ErrorJSON(responseRecorder, err)
+		}
+
+		if responseRecorder.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, responseRecorder.Code)
+		}
+		if ct := responseRecorder.Header().Get("Content-Type"); ct != e.contentType {
+			t.Errorf("%s: expected content type %s but got %s", e.name, e.contentType, ct)
+		}
+		if e.body != "" && !bytes.Equal(responseRecorder.Body.Bytes(), []byte(e.body)) {
+			t.Errorf("%s: unexpected body %s", e.name, responseRecorder.Body.String())
+		}
+	}
+}
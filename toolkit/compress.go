@@ -0,0 +1,212 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// UnsupportedEncodingError is returned when a request body uses a
+// Content-Encoding other than gzip, deflate or identity.
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("content encoding %q is not supported", e.Encoding)
+}
+
+func (e *UnsupportedEncodingError) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// decodedBody wraps the request body in a decompressor matching its
+// Content-Encoding.
+func decodedBody(r *http.Request) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("body contains badly-formed gzip data: %w", err)
+		}
+		return reader, nil
+	case "deflate":
+		reader, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("body contains badly-formed deflate data: %w", err)
+		}
+		return reader, nil
+	}
+
+	return nil, &UnsupportedEncodingError{Encoding: encoding}
+}
+
+// readDecodedBody reads the decompressed body, failing once more than
+// maxBytes have been produced.
+func readDecodedBody(r *http.Request, maxBytes int) ([]byte, error) {
+	reader, err := decodedBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBytes {
+		return nil, &JSONTooLargeError{Limit: int64(maxBytes)}
+	}
+
+	return body, nil
+}
+
+// encodingResponseWriter remembers the request's Accept-Encoding header so
+// WriteJSON can compress responses. See Compression.
+type encodingResponseWriter struct {
+	http.ResponseWriter
+	acceptEncoding string
+}
+
+func (e *encodingResponseWriter) Flush() {
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (e *encodingResponseWriter) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
+
+// Compression lets WriteJSON and ErrorJSON compress their responses for
+// handlers wrapped by it when no request is passed in WriteOptions. The
+// middleware passes the client's Accept-Encoding header along with the
+// ResponseWriter. Write, which takes the request, does not need it.
+func (t *Tools) Compression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&encodingResponseWriter{ResponseWriter: w, acceptEncoding: r.Header.Get("Accept-Encoding")}, r)
+	})
+}
+
+// acceptEncodingFor returns the Accept-Encoding header of r, or of the request
+// remembered by Compression when r is nil.
+func acceptEncodingFor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r != nil {
+		return r.Header.Get("Accept-Encoding"), true
+	}
+	for w != nil {
+		if ew, ok := w.(*encodingResponseWriter); ok {
+			return ew.acceptEncoding, true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = unwrapper.Unwrap()
+	}
+
+	return "", false
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, or
+// returns "" when neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities, wildcard := map[string]float64{}, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		if coding == "*" {
+			wildcard = quality
+		} else {
+			qualities[coding] = quality
+		}
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+
+	return best
+}
+
+// compressBody compresses out with encoding, which must be gzip or deflate.
+func compressBody(out []byte, encoding string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	if encoding == "gzip" {
+		writer = gzip.NewWriter(&buffer)
+	} else {
+		writer = zlib.NewWriter(&buffer)
+	}
+
+	if _, err := writer.Write(out); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// responseEncoding returns the encoding to compress out with, or "" to send
+// it as it is: out is compressed when the client accepts it and it is at
+// least CompressionThreshold bytes long (1024 by default; a negative
+// threshold turns compression off). It adds Vary: Accept-Encoding whenever
+// the choice depended on the request.
+func (t *Tools) responseEncoding(w http.ResponseWriter, r *http.Request, out []byte) string {
+	threshold := 1024
+	if t.CompressionThreshold != 0 {
+		threshold = t.CompressionThreshold
+	}
+
+	acceptEncoding, known := acceptEncodingFor(w, r)
+	if !known || threshold < 0 || len(out) < threshold {
+		return ""
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if w.Header().Get("Content-Encoding") != "" {
+		return ""
+	}
+
+	return negotiateEncoding(acceptEncoding)
+}
+
+// compressResponse compresses out with encoding, setting the response headers
+// to match.
+func compressResponse(w http.ResponseWriter, out []byte, encoding string) ([]byte, error) {
+	compressed, err := compressBody(out, encoding)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Del("Content-Length")
+
+	return compressed, nil
+}
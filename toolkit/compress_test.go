@@ -0,0 +1,149 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipString(t *testing.T, s string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func deflateString(t *testing.T, s string) []byte {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestTools_ReadJSONCompressed(t *testing.T) {
+	large := `{"foo": "` + strings.Repeat("a", 200) + `"}`
+
+	var compressedTests = []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxSize  int
+		err      interface{}
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipString(t, `{"foo": "bar"}`)},
+		{name: "deflate", encoding: "deflate", body: deflateString(t, `{"foo": "bar"}`)},
+		{name: "gzip bomb", encoding: "gzip", body: gzipString(t, large), maxSize: 100, err: &JSONTooLargeError{}},
+		{name: "unsupported", encoding: "br", body: []byte(`{"foo": "bar"}`), err: &UnsupportedEncodingError{}},
+		{name: "not gzip", encoding: "gzip", body: []byte(`{"foo": "bar"}`), err: errors.New("")},
+	}
+
+	for _, e := range compressedTests {
+		testTools := Tools{MaxJSONSize: e.maxSize}
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(e.body))
+		request.Header.Set("Content-Encoding", e.encoding)
+
+		var payload struct {
+			Foo string `json:"foo"`
+		}
+		err := testTools.ReadJson(httptest.NewRecorder(), request, &payload)
+
+		switch target := e.err.(type) {
+		case nil:
+			if err != nil || payload.Foo != "bar" {
+				t.Errorf("%s: expected foo to be bar, got %q (%v)", e.name, payload.Foo, err)
+			}
+		case *JSONTooLargeError:
+			if !errors.As(err, &target) {
+				t.Errorf("%s: expected *JSONTooLargeError but got %v", e.name, err)
+			}
+		case *UnsupportedEncodingError:
+			if !errors.As(err, &target) {
+				t.Errorf("%s: expected *UnsupportedEncodingError but got %v", e.name, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("%s: error expected, but none received", e.name)
+			}
+		}
+	}
+}
+
+var compressResponseTests = []struct {
+	name           string
+	acceptEncoding string
+	size           int
+	threshold      int
+	encoding       string
+}{
+	{name: "gzip", acceptEncoding: "gzip, deflate", size: 2000, encoding: "gzip"},
+	{name: "deflate preferred", acceptEncoding: "gzip;q=0.5, deflate", size: 2000, encoding: "deflate"},
+	{name: "below threshold", acceptEncoding: "gzip", size: 10, encoding: ""},
+	{name: "custom threshold", acceptEncoding: "gzip", size: 10, threshold: 5, encoding: "gzip"},
+	{name: "disabled", acceptEncoding: "gzip", size: 2000, threshold: -1, encoding: ""},
+	{name: "not accepted", acceptEncoding: "br", size: 2000, encoding: ""},
+	{name: "refused", acceptEncoding: "gzip;q=0, *", size: 2000, encoding: "deflate"},
+	{name: "no header", size: 2000, encoding: ""},
+}
+
+func TestTools_WriteJSONCompressed(t *testing.T) {
+	for _, e := range compressResponseTests {
+		testTools := Tools{CompressionThreshold: e.threshold}
+		payload := JSONResponse{Message: strings.Repeat("m", e.size)}
+
+		handler := testTools.Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = testTools.WriteJSON(w, http.StatusOK, payload)
+		}))
+
+		request := httptest.NewRequest("GET", "/", nil)
+		if e.acceptEncoding != "" {
+			request.Header.Set("Accept-Encoding", e.acceptEncoding)
+		}
+		responseRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, request)
+
+		if got := responseRecorder.Header().Get("Content-Encoding"); got != e.encoding {
+			t.Errorf("%s: expected content encoding %q but got %q", e.name, e.encoding, got)
+			continue
+		}
+
+		var body io.Reader = responseRecorder.Body
+		switch e.encoding {
+		case "gzip":
+			reader, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = reader
+		case "deflate":
+			reader, err := zlib.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = reader
+		}
+
+		out, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), payload.Message) {
+			t.Errorf("%s: response body did not round trip", e.name)
+		}
+	}
+}
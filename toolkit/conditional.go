@@ -0,0 +1,100 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WriteOptions controls how WriteJSON and the other write methods send a
+// response.
+type WriteOptions struct {
+	// Headers are copied onto the response before it is written.
+	Headers http.Header
+	// Request is the request being answered. It is needed for conditional
+	// requests and lets responses be compressed without the Compression
+	// middleware.
+	Request *http.Request
+	// ETag adds a strong ETag computed over the marshalled body and, with
+	// Request set, answers a matching If-None-Match with 304 Not Modified.
+	ETag bool
+	// LastModified sets the Last-Modified header and, with Request set,
+	// answers a satisfied If-Modified-Since with 304 Not Modified.
+	LastModified time.Time
+	// CacheControl sets the Cache-Control header.
+	CacheControl string
+}
+
+// writeOptions returns the first of opts, or the zero value.
+func writeOptions(opts []WriteOptions) WriteOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+
+	return WriteOptions{}
+}
+
+// applyHeaders copies the caching headers and any extra headers in opts onto
+// the response.
+func applyHeaders(w http.ResponseWriter, opts WriteOptions) {
+	for key, value := range opts.Headers {
+		w.Header()[key] = value
+	}
+	if opts.CacheControl != "" {
+		w.Header().Set("Cache-Control", opts.CacheControl)
+	}
+	if !opts.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", opts.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// strongETag returns a quoted strong entity tag for body.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag using the
+// weak comparison RFC 7232 requires for that header. Tags that only differ by
+// the content-coding suffix added for compressed responses also match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	opaque := strings.Trim(etag, `"`)
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.Trim(strings.TrimPrefix(strings.TrimSpace(candidate), "W/"), `"`)
+		candidate = strings.TrimSuffix(strings.TrimSuffix(candidate, "-gzip"), "-deflate")
+		if candidate == opaque {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified reports whether the request in opts already holds the current
+// representation, identified by etag and opts.LastModified.
+func notModified(opts WriteOptions, status int, etag string) bool {
+	r := opts.Request
+	if r == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if status < 200 || status > 299 {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etag != "" && etagMatches(ifNoneMatch, etag)
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !opts.LastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !opts.LastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
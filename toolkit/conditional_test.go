@@ -0,0 +1,122 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_WriteJSONETag(t *testing.T) {
+	var testTools Tools
+	payload := JSONResponse{Message: "foo"}
+
+	first := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/", nil)
+	err := testTools.WriteJSON(first, http.StatusOK, payload, WriteOptions{Request: request, ETag: true, CacheControl: "no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag := first.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || first.Code != http.StatusOK || first.Body.Len() == 0 {
+		t.Fatalf("expected a 200 with a strong ETag, got %d %q", first.Code, etag)
+	}
+
+	var etagTests = []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		payload     JSONResponse
+		status      int
+	}{
+		{name: "matching", method: "GET", ifNoneMatch: etag, payload: payload, status: http.StatusNotModified},
+		{name: "weak match", method: "GET", ifNoneMatch: `"other", W/` + etag, payload: payload, status: http.StatusNotModified},
+		{name: "wildcard", method: "HEAD", ifNoneMatch: "*", payload: payload, status: http.StatusNotModified},
+		{name: "changed", method: "GET", ifNoneMatch: etag, payload: JSONResponse{Message: "bar"}, status: http.StatusOK},
+		{name: "unsafe method", method: "POST", ifNoneMatch: etag, payload: payload, status: http.StatusOK},
+	}
+
+	for _, e := range etagTests {
+		request := httptest.NewRequest(e.method, "/", nil)
+		request.Header.Set("If-None-Match", e.ifNoneMatch)
+		responseRecorder := httptest.NewRecorder()
+
+		err := testTools.WriteJSON(responseRecorder, http.StatusOK, e.payload, WriteOptions{Request: request, ETag: true, CacheControl: "no-cache"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if responseRecorder.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, responseRecorder.Code)
+		}
+		if e.status == http.StatusNotModified && responseRecorder.Body.Len() != 0 {
+			t.Errorf("%s: expected an empty body on 304", e.name)
+		}
+		if responseRecorder.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("%s: expected Cache-Control to be set", e.name)
+		}
+	}
+}
+
+func TestTools_WriteJSONLastModified(t *testing.T) {
+	var testTools Tools
+	modified := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	var lastModifiedTests = []struct {
+		name   string
+		since  time.Time
+		status int
+	}{
+		{name: "not modified", since: modified, status: http.StatusNotModified},
+		{name: "modified", since: modified.Add(-time.Hour), status: http.StatusOK},
+	}
+
+	for _, e := range lastModifiedTests {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("If-Modified-Since", e.since.Format(http.TimeFormat))
+		responseRecorder := httptest.NewRecorder()
+
+		err := testTools.WriteJSON(responseRecorder, http.StatusOK, JSONResponse{Message: "foo"}, WriteOptions{Request: request, LastModified: modified})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if responseRecorder.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, responseRecorder.Code)
+		}
+		if responseRecorder.Header().Get("Last-Modified") != "Wed, 01 Mar 2023 12:00:00 GMT" {
+			t.Errorf("%s: wrong Last-Modified %q", e.name, responseRecorder.Header().Get("Last-Modified"))
+		}
+	}
+}
+
+func TestTools_WriteJSONETagCompressed(t *testing.T) {
+	testTools := Tools{CompressionThreshold: 1}
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	first := httptest.NewRecorder()
+	_ = testTools.WriteJSON(first, http.StatusOK, JSONResponse{Message: "foo"}, WriteOptions{Request: request, ETag: true})
+	etag := first.Header().Get("ETag")
+	if !strings.HasSuffix(etag, `-gzip"`) {
+		t.Fatalf("expected ETag of compressed response to name the encoding, got %s", etag)
+	}
+
+	request.Header.Set("If-None-Match", etag)
+	second := httptest.NewRecorder()
+	_ = testTools.WriteJSON(second, http.StatusOK, JSONResponse{Message: "foo"}, WriteOptions{Request: request, ETag: true})
+	if second.Code != http.StatusNotModified {
+		t.Errorf("expected 304 but got %d", second.Code)
+	}
+	if second.Header().Get("ETag") != etag {
+		t.Errorf("expected the 304 to carry the ETag %s of the compressed response, got %s", etag, second.Header().Get("ETag"))
+	}
+	if second.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected the 304 to carry Vary: Accept-Encoding, got %q", second.Header().Get("Vary"))
+	}
+	if second.Header().Get("Content-Encoding") != "" || second.Body.Len() != 0 {
+		t.Errorf("expected the 304 to have no body, got %q encoded as %q", second.Body, second.Header().Get("Content-Encoding"))
+	}
+}
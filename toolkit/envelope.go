@@ -0,0 +1,98 @@
+package toolkit
+
+import (
+	"encoding/json"
+)
+
+// Envelope names the members of the object a JSONResponse is written as. An
+// empty name keeps the default and "-" leaves the member out. The zero value
+// writes {"error", "message", "data", "meta"} as JSONResponse's own tags do;
+// an envelope such as
+//
+//	Envelope{Success: "success", Error: "-", Data: "result", Errors: "errors"}
+//
+// writes {"success": true, "message": ..., "result": ..., "meta": ...} and
+// puts the error data of ErrorJSON responses under "errors".
+type Envelope struct {
+	// Success holds true for successful responses and false for errors. It
+	// is left out by default.
+	Success string
+	// Error holds true for error responses. It defaults to "error".
+	Error   string
+	Message string
+	Data    string
+	// Errors holds the data of error responses. It defaults to Data.
+	Errors string
+	Meta   string
+}
+
+// envelopeKey returns the configured key, the default when it is empty, or
+// "" when the member is left out.
+func envelopeKey(key, fallback string) string {
+	switch key {
+	case "-":
+		return ""
+	case "":
+		return fallback
+	}
+
+	return key
+}
+
+// marshalEnvelope marshals response using the envelope configured on t,
+// keeping the members in a fixed order.
+func (t *Tools) marshalEnvelope(response JSONResponse) ([]byte, error) {
+	envelope := t.Envelope
+	dataKey := envelopeKey(envelope.Data, "data")
+	if response.Error {
+		dataKey = envelopeKey(envelope.Errors, dataKey)
+	}
+
+	var members []json.RawMessage
+	add := func(key string, value interface{}, omitEmpty bool) error {
+		if key == "" || (omitEmpty && value == nil) {
+			return nil
+		}
+
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		encodedValue, err := marshalRedacted(value)
+		if err != nil {
+			return err
+		}
+		members = append(members, append(append(encodedKey, ':'), encodedValue...))
+
+		return nil
+	}
+
+	for _, err := range []error{
+		add(envelopeKey(envelope.Success, ""), !response.Error, false),
+		add(envelopeKey(envelope.Error, "error"), response.Error, false),
+		add(envelopeKey(envelope.Message, "message"), response.Message, false),
+		add(dataKey, response.Data, true),
+		add(envelopeKey(envelope.Meta, "meta"), response.Meta, true),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return joinJSON('{', '}', members), nil
+}
+
+// marshalJSON marshals data for a response: JSONResponse values are written
+// in the configured envelope, and redacted fields are left out.
+func (t *Tools) marshalJSON(data interface{}) ([]byte, error) {
+	switch response := data.(type) {
+	case JSONResponse:
+		return t.marshalEnvelope(response)
+	case *JSONResponse:
+		if response != nil {
+			return t.marshalEnvelope(*response)
+		}
+	}
+
+	return marshalRedacted(data)
+}
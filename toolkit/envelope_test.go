@@ -0,0 +1,51 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTools_WriteJSONEnvelope(t *testing.T) {
+	var envelopeTests = []struct {
+		name     string
+		envelope Envelope
+		error    bool
+		payload  JSONResponse
+		expected string
+	}{
+		{name: "default", payload: JSONResponse{Message: "ok", Data: 1}, expected: `{"error":false,"message":"ok","data":1}`},
+		{name: "default with meta", payload: JSONResponse{Meta: map[string]int{"total": 2}}, expected: `{"error":false,"message":"","meta":{"total":2}}`},
+		{
+			name:     "custom",
+			envelope: Envelope{Success: "success", Error: "-", Message: "-", Data: "result", Errors: "errors"},
+			payload:  JSONResponse{Data: []int{1, 2}, Meta: "m"},
+			expected: `{"success":true,"result":[1,2],"meta":"m"}`,
+		},
+		{
+			name:     "custom error",
+			envelope: Envelope{Success: "success", Error: "-", Data: "result", Errors: "errors"},
+			error:    true,
+			expected: `{"success":false,"message":"validation failed: name is required","errors":{"name":["is required"]}}`,
+		},
+	}
+
+	for _, e := range envelopeTests {
+		testTools := Tools{Envelope: e.envelope}
+		rr := httptest.NewRecorder()
+
+		var err error
+		if e.error {
+			err = testTools.ErrorJSON(rr, ValidationErrors{"name": {"is required"}})
+		} else {
+			err = testTools.WriteJSON(rr, http.StatusOK, e.payload)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		if rr.Body.String() != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, rr.Body.String())
+		}
+	}
+}
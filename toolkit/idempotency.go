@@ -0,0 +1,311 @@
+package toolkit
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is what an IdempotencyStore keeps for a key: the
+// fingerprint of the first request and, once it has been handled, its
+// response.
+type IdempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	// Status is 0 while the first request is being handled.
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps idempotency records. Implementations must be safe
+// for concurrent use and, when shared between processes, make Reserve
+// atomic.
+type IdempotencyStore interface {
+	// Reserve stores record for key until expires, unless key already has a
+	// record that has not expired. It returns that record, or nil if record
+	// was stored.
+	Reserve(ctx context.Context, key string, record IdempotencyRecord, expires time.Time) (*IdempotencyRecord, error)
+	// Save replaces the record for key.
+	Save(ctx context.Context, key string, record IdempotencyRecord, expires time.Time) error
+	// Delete removes the record for key.
+	Delete(ctx context.Context, key string) error
+}
+
+// memoryIdempotencyStore keeps records in a map, and their expiry times in a
+// heap so that expired records are dropped without scanning the map.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]memoryIdempotencyEntry
+	expiry  expiryHeap
+}
+
+type memoryIdempotencyEntry struct {
+	record  IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore keeping its records
+// in memory, for a single process.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]memoryIdempotencyEntry{}}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, key string, record IdempotencyRecord, expires time.Time) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	if entry, ok := s.records[key]; ok {
+		existing := entry.record
+		return &existing, nil
+	}
+	s.store(key, record, expires)
+
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Save(ctx context.Context, key string, record IdempotencyRecord, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store(key, record, expires)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// store records key and its expiry. The caller must hold s.mu.
+func (s *memoryIdempotencyStore) store(key string, record IdempotencyRecord, expires time.Time) {
+	s.records[key] = memoryIdempotencyEntry{record: record, expires: expires}
+	heap.Push(&s.expiry, keyExpiry{key: key, expires: expires})
+}
+
+// expire drops the records that have expired. Heap entries left behind by a
+// record saved again or deleted are skipped. The caller must hold s.mu.
+func (s *memoryIdempotencyStore) expire() {
+	now := time.Now()
+	for len(s.expiry) > 0 && now.After(s.expiry[0].expires) {
+		expired := heap.Pop(&s.expiry).(keyExpiry)
+		if entry, ok := s.records[expired.key]; ok && entry.expires.Equal(expired.expires) {
+			delete(s.records, expired.key)
+		}
+	}
+}
+
+// IdempotencyConfig configures the IdempotencyKeys middleware.
+type IdempotencyConfig struct {
+	// Store defaults to an in-memory store.
+	Store IdempotencyStore
+	// TTL is how long a response is replayed. It defaults to 24 hours.
+	TTL time.Duration
+	// LockTimeout is how long a key stays locked by a request that is still
+	// being handled, in case its process dies. It defaults to 1 minute.
+	LockTimeout time.Duration
+	// Header defaults to Idempotency-Key.
+	Header string
+	// Required rejects requests without the header.
+	Required bool
+	// Scope identifies the caller of a request, so that callers using the
+	// same key do not see each other's responses. It defaults to the
+	// Authorization header.
+	Scope func(r *http.Request) string
+}
+
+// IdempotencyError is returned, and sent with Status, when a request's
+// idempotency key cannot be used: it is missing or invalid (400), still
+// locked by a request being handled (409), or was used for a different
+// request (422).
+type IdempotencyError struct {
+	Key    string
+	Reason string
+	Status int
+}
+
+func (e *IdempotencyError) Error() string {
+	return "idempotency key rejected: " + e.Reason
+}
+
+func (e *IdempotencyError) StatusCode() int {
+	return e.Status
+}
+
+// IdempotencyKeys is middleware that makes POST and PATCH requests carrying
+// an Idempotency-Key header safe to retry. The first response for a key is
+// stored, with its status, headers and body, and replayed for later requests
+// with the same key, which next does not see; replays carry an
+// Idempotent-Replayed header. A key reused for a different method, path or
+// body is refused, as is a key whose first request is still being handled.
+// Responses with a 5xx status are not stored, so that the request can be
+// retried. Keys are scoped by t.Idempotency.Scope, and Set-Cookie headers are
+// never replayed. The body is limited to MaxJSONSize and left for next to read.
+// It is configured by t.Idempotency.
+func (t *Tools) IdempotencyKeys(next http.Handler) http.Handler {
+	var config IdempotencyConfig
+	if t.Idempotency != nil {
+		config = *t.Idempotency
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryIdempotencyStore()
+	}
+	if config.TTL == 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.LockTimeout == 0 {
+		config.LockTimeout = time.Minute
+	}
+	if config.Header == "" {
+		config.Header = "Idempotency-Key"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get(config.Header)
+		if key == "" {
+			if config.Required {
+				_ = t.ErrorJSON(w, &IdempotencyError{Reason: config.Header + " header is required", Status: http.StatusBadRequest})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			_ = t.ErrorJSON(w, &IdempotencyError{Reason: "key must not be longer than 255 characters", Status: http.StatusBadRequest})
+			return
+		}
+
+		maxBytes := 1024 * 1024
+		if t.MaxJSONSize != 0 {
+			maxBytes = t.MaxJSONSize
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = &JSONTooLargeError{Limit: int64(maxBytes)}
+			}
+			_ = t.ErrorJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		// Keys are only unique for a caller. The scope is hashed so that no
+		// credentials reach the store.
+		scope := r.Header.Get("Authorization")
+		if config.Scope != nil {
+			scope = config.Scope(r)
+		}
+		scoped := sha256.Sum256([]byte(scope + "\x00" + key))
+		storeKey := hex.EncodeToString(scoped[:])
+
+		ctx := r.Context()
+		existing, err := config.Store.Reserve(ctx, storeKey, IdempotencyRecord{Fingerprint: fingerprint}, time.Now().Add(config.LockTimeout))
+		if err != nil {
+			_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				_ = t.ErrorJSON(w, &IdempotencyError{Key: key, Reason: "key was used for a different request", Status: http.StatusUnprocessableEntity})
+			case existing.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				_ = t.ErrorJSON(w, &IdempotencyError{Key: key, Reason: "a request with this key is in progress", Status: http.StatusConflict})
+			default:
+				for name, values := range existing.Header {
+					if name != "Set-Cookie" {
+						w.Header()[name] = values
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				_, _ = w.Write(existing.Body)
+			}
+			return
+		}
+
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+		stored := false
+		defer func() {
+			// Release the key if next panicked or failed, so that the
+			// request can be retried.
+			if !stored {
+				_ = config.Store.Delete(context.Background(), storeKey)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+			recorder.header = w.Header().Clone()
+		}
+		if recorder.status >= 500 {
+			return
+		}
+
+		// Cookies start sessions for whoever made the first request; they
+		// are not stored, so a replay cannot hand them to someone else.
+		recorder.header.Del("Set-Cookie")
+		record := IdempotencyRecord{Fingerprint: fingerprint, Status: recorder.status, Header: recorder.header, Body: recorder.body.Bytes()}
+		if err := config.Store.Save(context.Background(), storeKey, record, time.Now().Add(config.TTL)); err == nil {
+			stored = true
+		}
+	})
+}
+
+// recordingResponseWriter keeps a copy of the response written through it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+		rw.header = rw.ResponseWriter.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
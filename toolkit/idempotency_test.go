@@ -0,0 +1,177 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTools_IdempotencyKeys(t *testing.T) {
+	var created int32
+	testTools := Tools{Idempotency: &IdempotencyConfig{}}
+	handler := testTools.IdempotencyKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var item clientTestItem
+		if err := testTools.ReadJson(w, r, &item); err != nil {
+			_ = testTools.ErrorJSON(w, err)
+			return
+		}
+		if item.Name == "fail" {
+			_ = testTools.ErrorJSON(w, http.ErrBodyNotAllowed, http.StatusInternalServerError)
+			return
+		}
+		n := atomic.AddInt32(&created, 1)
+		w.Header().Set("X-Record", strings.Repeat("*", int(n)))
+		_ = testTools.WriteJSON(w, http.StatusCreated, item)
+	}))
+
+	var tests = []struct {
+		name          string
+		method        string
+		key           string
+		body          string
+		expectStatus  int
+		expectCreated int32
+		expectReplay  bool
+	}{
+		{"first request", http.MethodPost, "k1", `{"id":1,"name":"a"}`, http.StatusCreated, 1, false},
+		{"retried request", http.MethodPost, "k1", `{"id":1,"name":"a"}`, http.StatusCreated, 1, true},
+		{"different body", http.MethodPost, "k1", `{"id":2,"name":"b"}`, http.StatusUnprocessableEntity, 1, false},
+		{"new key", http.MethodPost, "k2", `{"id":1,"name":"a"}`, http.StatusCreated, 2, false},
+		{"no key", http.MethodPost, "", `{"id":1,"name":"a"}`, http.StatusCreated, 3, false},
+		{"put is not handled", http.MethodPut, "k1", `{"id":1,"name":"a"}`, http.StatusCreated, 4, false},
+		{"server error", http.MethodPost, "k3", `{"name":"fail"}`, http.StatusInternalServerError, 4, false},
+		{"retried server error", http.MethodPost, "k3", `{"name":"fail"}`, http.StatusInternalServerError, 4, false},
+		{"key too long", http.MethodPost, strings.Repeat("k", 256), `{}`, http.StatusBadRequest, 4, false},
+	}
+
+	var first *httptest.ResponseRecorder
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, "/orders", strings.NewReader(e.body))
+		if e.key != "" {
+			req.Header.Set("Idempotency-Key", e.key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", e.name, e.expectStatus, rr.Code, rr.Body)
+		}
+		if atomic.LoadInt32(&created) != e.expectCreated {
+			t.Errorf("%s: expected %d records created, got %d", e.name, e.expectCreated, created)
+		}
+		if (rr.Header().Get("Idempotent-Replayed") != "") != e.expectReplay {
+			t.Errorf("%s: unexpected Idempotent-Replayed header %q", e.name, rr.Header().Get("Idempotent-Replayed"))
+		}
+		if first == nil {
+			first = rr
+		}
+		if e.expectReplay && (rr.Body.String() != first.Body.String() || rr.Header().Get("X-Record") != first.Header().Get("X-Record")) {
+			t.Errorf("%s: expected the first response to be replayed, got %s %v", e.name, rr.Body, rr.Header())
+		}
+	}
+
+	testTools.Idempotency.Required = true
+	rr := httptest.NewRecorder()
+	testTools.IdempotencyKeys(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing key to be rejected when required, got %d", rr.Code)
+	}
+}
+
+func TestTools_IdempotencyKeys_Concurrent(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var testTools Tools
+	handler := testTools.IdempotencyKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"job":1}`))
+		req.Header.Set("Idempotency-Key", "job-1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- request() }()
+	<-started
+
+	if rr := request(); rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a concurrent duplicate to be refused with 409, got %d", rr.Code)
+	}
+
+	close(release)
+	if rr := <-done; rr.Code != http.StatusAccepted {
+		t.Errorf("expected the first request to complete, got %d", rr.Code)
+	}
+	if rr := request(); rr.Code != http.StatusAccepted || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the completed response to be replayed, got %d", rr.Code)
+	}
+}
+
+func TestTools_IdempotencyKeys_Scope(t *testing.T) {
+	var created int32
+	var testTools Tools
+	handler := testTools.IdempotencyKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&created, 1)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: r.Header.Get("Authorization")})
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	request := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("Authorization", authorization)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := request("Bearer alice"); rr.Header().Get("Set-Cookie") == "" {
+		t.Error("expected the first response to set its cookie")
+	}
+	if rr := request("Bearer bob"); rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("expected another caller's request with the same key not to be replayed")
+	}
+	rr := request("Bearer alice")
+	if rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected the caller's retry to be replayed")
+	}
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Errorf("expected Set-Cookie not to be replayed, got %q", rr.Header().Get("Set-Cookie"))
+	}
+	if created != 2 {
+		t.Errorf("expected 2 records created, got %d", created)
+	}
+}
+
+func TestMemoryIdempotencyStore_Expiry(t *testing.T) {
+	store := NewMemoryIdempotencyStore().(*memoryIdempotencyStore)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, _ = store.Reserve(ctx, "old", IdempotencyRecord{Fingerprint: "a"}, now.Add(-time.Second))
+	_, _ = store.Reserve(ctx, "saved", IdempotencyRecord{Fingerprint: "b"}, now.Add(-time.Second))
+	_ = store.Save(ctx, "saved", IdempotencyRecord{Fingerprint: "b", Status: http.StatusOK}, now.Add(time.Hour))
+
+	existing, _ := store.Reserve(ctx, "new", IdempotencyRecord{Fingerprint: "c"}, now.Add(time.Hour))
+	if existing != nil {
+		t.Error("expected a new key to be reserved")
+	}
+	if _, ok := store.records["old"]; ok {
+		t.Error("expected the expired record to be dropped")
+	}
+	if existing, _ := store.Reserve(ctx, "saved", IdempotencyRecord{}, now.Add(time.Hour)); existing == nil || existing.Status != http.StatusOK {
+		t.Error("expected a record saved with a later expiry to be kept")
+	}
+	if len(store.expiry) != 2 {
+		t.Errorf("expected 2 pending expiries, got %d", len(store.expiry))
+	}
+}
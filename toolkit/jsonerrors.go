@@ -0,0 +1,344 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// errorDataProvider is implemented by errors that carry a machine-readable
+// description for ErrorJSON to send as the response data.
+type errorDataProvider interface {
+	ErrorData() interface{}
+}
+
+// JSONErrorDetail is the machine-readable form of a JSON decoding error, sent
+// as the data member of an ErrorJSON response.
+type JSONErrorDetail struct {
+	Code     string `json:"code"`
+	Path     string `json:"path,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Limit    int64  `json:"limit,omitempty"`
+}
+
+// JSONSyntaxError is returned when the body is not well-formed JSON.
+type JSONSyntaxError struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+func (e *JSONSyntaxError) Error() string {
+	if e.Offset == 0 {
+		return "body contains badly-formed JSON"
+	}
+	return fmt.Sprintf("body contains badly-formed JSON (at line %d, column %d)", e.Line, e.Column)
+}
+
+func (e *JSONSyntaxError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "syntax_error", Offset: e.Offset, Line: e.Line, Column: e.Column}
+}
+
+// JSONTypeError is returned when a JSON value cannot be stored in the Go type
+// at Path.
+type JSONTypeError struct {
+	Offset   int64
+	Line     int
+	Column   int
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *JSONTypeError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("body contains incorrect JSON type for field %q (expected %s, got %s)", e.Path, e.Expected, e.Actual)
+	}
+	return fmt.Sprintf("body contains incorrect JSON type (at line %d, column %d)", e.Line, e.Column)
+}
+
+func (e *JSONTypeError) ErrorData() interface{} {
+	return JSONErrorDetail{
+		Code:     "type_mismatch",
+		Path:     e.Path,
+		Offset:   e.Offset,
+		Line:     e.Line,
+		Column:   e.Column,
+		Expected: e.Expected,
+		Actual:   e.Actual,
+	}
+}
+
+// JSONUnknownFieldError is returned when unknown fields are not allowed and the
+// body contains a key that has no matching struct field.
+type JSONUnknownFieldError struct {
+	Offset int64
+	Line   int
+	Column int
+	Path   string
+	Field  string
+}
+
+func (e *JSONUnknownFieldError) Error() string {
+	return fmt.Sprintf("body contains unknown key %q", e.Path)
+}
+
+func (e *JSONUnknownFieldError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "unknown_field", Path: e.Path, Offset: e.Offset, Line: e.Line, Column: e.Column}
+}
+
+// JSONTooLargeError is returned when the body exceeds MaxJSONSize.
+type JSONTooLargeError struct {
+	Limit int64
+}
+
+func (e *JSONTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+func (e *JSONTooLargeError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "too_large", Limit: e.Limit}
+}
+
+// JSONEmptyBodyError is returned when the body contains no JSON value at all.
+type JSONEmptyBodyError struct{}
+
+func (e *JSONEmptyBodyError) Error() string {
+	return "body must not be empty"
+}
+
+func (e *JSONEmptyBodyError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "empty_body"}
+}
+
+// JSONMultipleValuesError is returned when the body holds more than one JSON
+// value; Offset points at the start of the second one.
+type JSONMultipleValuesError struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+func (e *JSONMultipleValuesError) Error() string {
+	return "body must contain only one JSON value"
+}
+
+func (e *JSONMultipleValuesError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "multiple_values", Offset: e.Offset, Line: e.Line, Column: e.Column}
+}
+
+// lineColumn converts the offset reported by encoding/json (the number of
+// bytes read when the error occurred) to a 1-based line and column.
+func lineColumn(data []byte, offset int64) (int, int) {
+	pos := int(offset) - 1
+	if pos > len(data) {
+		pos = len(data)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	before := data[:pos]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := pos - bytes.LastIndexByte(before, '\n')
+
+	return line, column
+}
+
+type jsonPathFrame struct {
+	array   bool
+	index   int
+	key     string
+	wantKey bool
+}
+
+// jsonPathWalker tracks the path of the value currently being read from a
+// token stream, e.g. items[2].name.
+type jsonPathWalker struct {
+	stack []jsonPathFrame
+}
+
+func (p *jsonPathWalker) path() string {
+	var b strings.Builder
+	for _, f := range p.stack {
+		if f.array {
+			b.WriteString("[" + strconv.Itoa(f.index) + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(f.key)
+	}
+
+	return b.String()
+}
+
+// next feeds one token to the walker. It reports whether the token was an
+// object key and, for a value, the path at which that value sits.
+func (p *jsonPathWalker) next(tok json.Token) (path string, isKey bool) {
+	if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+		p.stack = p.stack[:len(p.stack)-1]
+		return "", false
+	}
+
+	if len(p.stack) > 0 {
+		top := &p.stack[len(p.stack)-1]
+		if !top.array && top.wantKey {
+			top.key, top.wantKey = tok.(string), false
+			return p.path(), true
+		}
+		if top.array {
+			top.index++
+		} else {
+			top.wantKey = true
+		}
+	}
+
+	path = p.path()
+	if d, ok := tok.(json.Delim); ok {
+		p.stack = append(p.stack, jsonPathFrame{array: d == '[', index: -1, wantKey: d == '{'})
+	}
+
+	return path, false
+}
+
+// jsonPathAtOffset returns the path of the last value that starts before
+// offset in data.
+func jsonPathAtOffset(data []byte, offset int64) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var walker jsonPathWalker
+	path := ""
+	for decoder.InputOffset() < offset {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			walker.next(tok)
+			continue
+		}
+		if p, isKey := walker.next(tok); !isKey {
+			path = p
+		}
+	}
+
+	return path
+}
+
+// jsonKeyLocation finds the key named name that encoding/json rejected as
+// an unknown field while decoding data into target: the first such key in an
+// object decoded into a struct without a matching field. It returns the key's
+// full path and the decoder's offset just past it. When the objects' types
+// cannot be followed, as through an Unmarshaler, the first key named name is
+// used.
+func jsonKeyLocation(data []byte, target interface{}, name string) (string, int64, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var walker jsonPathWalker
+	var types []reflect.Type
+	value := reflect.TypeOf(target)
+
+	var fallbackPath string
+	var fallbackOffset int64
+	fallback := false
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return fallbackPath, fallbackOffset, fallback
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			walker.next(tok)
+			types = types[:len(types)-1]
+			value = nextArrayElem(walker, types)
+			continue
+		}
+
+		path, isKey := walker.next(tok)
+		if isKey {
+			parent := types[len(types)-1]
+			_, value = jsonObjectMember(parent, tok.(string))
+			if tok.(string) != name {
+				continue
+			}
+			if parent != nil && parent.Kind() == reflect.Struct && value == nil {
+				return path, decoder.InputOffset(), true
+			}
+			if !fallback {
+				fallbackPath, fallbackOffset, fallback = path, decoder.InputOffset(), true
+			}
+			continue
+		}
+
+		if _, ok := tok.(json.Delim); ok {
+			types = append(types, decodedType(value))
+		}
+		value = nextArrayElem(walker, types)
+	}
+}
+
+// translateJSONError converts an error from encoding/json into one of the typed
+// errors above. data is the raw body that was being decoded into target.
+func translateJSONError(err error, data []byte, target interface{}) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		line, column := lineColumn(data, syntaxError.Offset)
+		return &JSONSyntaxError{Offset: syntaxError.Offset, Line: line, Column: column}
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		line, column := lineColumn(data, int64(len(data)))
+		return &JSONSyntaxError{Offset: int64(len(data)), Line: line, Column: column}
+
+	case errors.As(err, &unmarshalTypeError):
+		line, column := lineColumn(data, unmarshalTypeError.Offset)
+		path := jsonPathAtOffset(data, unmarshalTypeError.Offset)
+		if path == "" {
+			path = unmarshalTypeError.Field
+		}
+		expected := ""
+		if unmarshalTypeError.Type != nil {
+			expected = unmarshalTypeError.Type.String()
+		}
+		return &JSONTypeError{
+			Offset:   unmarshalTypeError.Offset,
+			Line:     line,
+			Column:   column,
+			Path:     path,
+			Expected: expected,
+			Actual:   unmarshalTypeError.Value,
+		}
+
+	case errors.Is(err, io.EOF):
+		return &JSONEmptyBodyError{}
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if unquoteErr != nil {
+			field = strings.TrimPrefix(err.Error(), "json: unknown field ")
+		}
+		unknown := &JSONUnknownFieldError{Field: field, Path: field}
+		if path, offset, ok := jsonKeyLocation(data, target, field); ok {
+			unknown.Path, unknown.Offset = path, offset
+			unknown.Line, unknown.Column = lineColumn(data, offset)
+		}
+		return unknown
+
+	case errors.As(err, &invalidUnmarshalError):
+		return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
+
+	default:
+		return err
+	}
+}
@@ -0,0 +1,125 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type jsonErrorTestItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type jsonErrorTestPayload struct {
+	Foo    string              `json:"foo"`
+	Items  []jsonErrorTestItem `json:"items"`
+	Labels map[string]string   `json:"labels"`
+}
+
+var jsonErrorTests = []struct {
+	name     string
+	json     string
+	maxSize  int
+	code     string
+	path     string
+	line     int
+	column   int
+	expected string
+}{
+	{name: "syntax", json: "{\"foo\":\n  }", code: "syntax_error", line: 2, column: 3},
+	{name: "unexpected eof", json: `{"foo": "bar"`, code: "syntax_error", line: 1, column: 13},
+	{name: "type", json: `{"foo": 1}`, code: "type_mismatch", path: "foo", line: 1, column: 9, expected: "string"},
+	{name: "nested type", json: `{"items": [{"name": "a", "count": 1}, {"name": "b", "count": "2"}]}`,
+		code: "type_mismatch", path: "items[1].count", line: 1, column: 64, expected: "int"},
+	{name: "unknown field", json: `{"items": [{"name": "a", "colour": "red"}]}`, code: "unknown_field", path: "items[0].colour", line: 1},
+	{name: "unknown field named like a map key", json: `{"labels": {"colour": "red"}, "items": [{"name": "a", "colour": "red"}]}`,
+		code: "unknown_field", path: "items[0].colour", line: 1, column: 62},
+	{name: "too large", json: `{"foo": "bar"}`, maxSize: 5, code: "too_large"},
+	{name: "empty", json: ``, code: "empty_body"},
+	{name: "multiple values", json: "{\"foo\": \"1\"}\n {\"foo\": \"2\"}", code: "multiple_values", line: 2, column: 2},
+}
+
+func TestTools_ReadJSONTypedErrors(t *testing.T) {
+	for _, e := range jsonErrorTests {
+		testTools := Tools{MaxJSONSize: e.maxSize}
+		var payload jsonErrorTestPayload
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+		err := testTools.ReadJson(httptest.NewRecorder(), request, &payload)
+		if err == nil {
+			t.Errorf("%s: error expected but none received", e.name)
+			continue
+		}
+
+		var provider errorDataProvider
+		if !errors.As(err, &provider) {
+			t.Errorf("%s: error %T does not carry error data", e.name, err)
+			continue
+		}
+
+		detail := provider.ErrorData().(JSONErrorDetail)
+		if detail.Code != e.code {
+			t.Errorf("%s: expected code %s but got %s", e.name, e.code, detail.Code)
+		}
+		if detail.Path != e.path {
+			t.Errorf("%s: expected path %q but got %q", e.name, e.path, detail.Path)
+		}
+		if e.line != 0 && detail.Line != e.line {
+			t.Errorf("%s: expected line %d but got %d", e.name, e.line, detail.Line)
+		}
+		if e.column != 0 && detail.Column != e.column {
+			t.Errorf("%s: expected column %d but got %d", e.name, e.column, detail.Column)
+		}
+		if detail.Expected != e.expected {
+			t.Errorf("%s: expected type %q but got %q", e.name, e.expected, detail.Expected)
+		}
+	}
+}
+
+func TestTools_ReadJSONErrorsAs(t *testing.T) {
+	var testTools Tools
+	var payload jsonErrorTestPayload
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": true}`)))
+	err := testTools.ReadJson(httptest.NewRecorder(), request, &payload)
+
+	var typeError *JSONTypeError
+	if !errors.As(err, &typeError) {
+		t.Fatalf("expected *JSONTypeError but got %T", err)
+	}
+	if typeError.Actual != "bool" {
+		t.Errorf("expected actual type bool but got %s", typeError.Actual)
+	}
+}
+
+func TestTools_ErrorJSONWithDetail(t *testing.T) {
+	var testTools Tools
+	responseRecorder := httptest.NewRecorder()
+
+	err := testTools.ErrorJSON(responseRecorder, &JSONTypeError{Path: "items[0].count", Expected: "int", Actual: "string"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	var payload struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    JSONErrorDetail `json:"data"`
+	}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&payload)
+	if err != nil {
+		t.Error("received error when decoding JSON", err)
+	}
+
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code returned; expected 400 but got %d", responseRecorder.Code)
+	}
+
+	if payload.Data.Code != "type_mismatch" || payload.Data.Path != "items[0].count" {
+		t.Errorf("unexpected error data: %+v", payload.Data)
+	}
+}
@@ -0,0 +1,320 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListConfig describes the query parameters a list endpoint accepts.
+type ListConfig struct {
+	// AllowedSort lists the fields that may appear in ?sort=.
+	AllowedSort []string
+	// AllowedFilter lists the fields that may appear in ?filter=.
+	AllowedFilter []string
+	// DefaultSort is used when the request has no ?sort=, e.g. "-created_at".
+	DefaultSort string
+	// DefaultPerPage defaults to 20 and MaxPerPage to 100.
+	DefaultPerPage int
+	MaxPerPage     int
+	// Cursor switches from ?page= to opaque ?cursor= pagination.
+	Cursor bool
+}
+
+// SortField is one field of a ?sort= parameter. A leading - in the parameter
+// sorts that field in descending order.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// ListParams holds the validated pagination, sorting and filtering parameters
+// of a list request.
+type ListParams struct {
+	Page    int
+	PerPage int
+	Cursor  string
+	Sort    []SortField
+	Filters map[string]string
+
+	cursorMode bool
+}
+
+// Offset returns the number of items to skip for offset pagination.
+func (p *ListParams) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// DecodeCursor decodes the request's cursor, produced by EncodeCursor, into v.
+// It does nothing when the request has no cursor.
+func (p *ListParams) DecodeCursor(v interface{}) error {
+	if p.Cursor == "" {
+		return nil
+	}
+
+	out, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil || json.Unmarshal(out, v) != nil {
+		return QueryErrors{"cursor": {"is not a valid cursor"}}
+	}
+
+	return nil
+}
+
+// EncodeCursor turns v, typically the sort key of the last item returned, into
+// an opaque cursor for PageResult.NextCursor.
+func EncodeCursor(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// QueryErrors maps a query parameter to the reasons it was rejected.
+type QueryErrors map[string][]string
+
+func (q QueryErrors) Error() string {
+	params := make([]string, 0, len(q))
+	for param := range q {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	messages := make([]string, 0, len(params))
+	for _, param := range params {
+		messages = append(messages, fmt.Sprintf("%s %s", param, strings.Join(q[param], ", ")))
+	}
+
+	return "invalid query parameters: " + strings.Join(messages, "; ")
+}
+
+func (q QueryErrors) ErrorData() interface{} {
+	return map[string][]string(q)
+}
+
+func (q QueryErrors) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (q QueryErrors) add(param, message string) {
+	q[param] = append(q[param], message)
+}
+
+// ReadListParams parses and validates ?page=, ?per_page= (or ?cursor=),
+// ?sort= and ?filter= against config. Filters are given either as
+// filter=field:value,field:value or as filter[field]=value. Every problem
+// found is returned together as QueryErrors.
+func (t *Tools) ReadListParams(r *http.Request, config ListConfig) (*ListParams, error) {
+	query := r.URL.Query()
+	errs := QueryErrors{}
+
+	defaultPerPage, maxPerPage := config.DefaultPerPage, config.MaxPerPage
+	if maxPerPage == 0 {
+		maxPerPage = 100
+	}
+	if defaultPerPage == 0 {
+		defaultPerPage = 20
+	}
+	if defaultPerPage > maxPerPage {
+		defaultPerPage = maxPerPage
+	}
+
+	params := &ListParams{Page: 1, PerPage: defaultPerPage, Filters: map[string]string{}, cursorMode: config.Cursor}
+
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			errs.add("per_page", "must be a whole number")
+		case perPage < 1 || perPage > maxPerPage:
+			errs.add("per_page", fmt.Sprintf("must be between 1 and %d", maxPerPage))
+		default:
+			params.PerPage = perPage
+		}
+	}
+
+	if config.Cursor {
+		params.Cursor = query.Get("cursor")
+		if query.Get("page") != "" {
+			errs.add("page", "is not supported; use cursor")
+		}
+	} else if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			errs.add("page", "must be a whole number")
+		case page < 1:
+			errs.add("page", "must be at least 1")
+		case page > maxPage(maxPerPage):
+			// Larger pages would overflow Offset.
+			errs.add("page", fmt.Sprintf("must be at most %d", maxPage(maxPerPage)))
+		default:
+			params.Page = page
+		}
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = config.DefaultSort
+	}
+	for _, field := range strings.Split(sortParam, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		sortField := SortField{Field: strings.TrimPrefix(field, "-"), Descending: strings.HasPrefix(field, "-")}
+		if !containsString(config.AllowedSort, sortField.Field) {
+			errs.add("sort", fmt.Sprintf("cannot sort by %q", sortField.Field))
+			continue
+		}
+		params.Sort = append(params.Sort, sortField)
+	}
+
+	for key, values := range query {
+		if key == "filter" {
+			for _, value := range values {
+				for _, pair := range strings.Split(value, ",") {
+					if strings.TrimSpace(pair) == "" {
+						continue
+					}
+					field, fieldValue, ok := strings.Cut(pair, ":")
+					if !ok {
+						errs.add("filter", fmt.Sprintf("%q must be in the form field:value", pair))
+						continue
+					}
+					addFilter(params, errs, config, strings.TrimSpace(field), fieldValue)
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, "filter[") && strings.HasSuffix(key, "]") {
+			addFilter(params, errs, config, key[len("filter["):len(key)-1], values[0])
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return params, nil
+}
+
+// maxPage is the highest page whose offset fits in 32 bits at up to
+// maxPerPage items a page.
+func maxPage(maxPerPage int) int {
+	return math.MaxInt32 / maxPerPage
+}
+
+func addFilter(params *ListParams, errs QueryErrors, config ListConfig, field, value string) {
+	if !containsString(config.AllowedFilter, field) {
+		errs.add("filter", fmt.Sprintf("cannot filter by %q", field))
+		return
+	}
+
+	params.Filters[field] = value
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PageResult is one page of a list, as passed to WritePage.
+type PageResult struct {
+	Items interface{}
+	// Total is the number of items across all pages. It is always reported
+	// for offset pagination, and for cursor pagination when non-zero.
+	Total      int
+	NextCursor string
+	PrevCursor string
+}
+
+// PageData is the data member of the JSONResponse written by WritePage.
+type PageData struct {
+	Items      interface{} `json:"items"`
+	Page       int         `json:"page,omitempty"`
+	PerPage    int         `json:"per_page"`
+	TotalItems *int        `json:"total_items,omitempty"`
+	TotalPages *int        `json:"total_pages,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
+
+// WritePage writes result as a JSONResponse whose data is a PageData, and adds
+// an RFC 8288 Link header pointing at the first, previous, next and last pages
+// (or the next and previous cursors). params must come from ReadListParams,
+// or at least have a positive PerPage.
+func (t *Tools) WritePage(w http.ResponseWriter, r *http.Request, params *ListParams, result PageResult, opts ...WriteOptions) error {
+	if params == nil {
+		return errors.New("WritePage needs the request's list params")
+	}
+	if !params.cursorMode && params.PerPage < 1 {
+		return fmt.Errorf("WritePage needs a positive PerPage, got %d", params.PerPage)
+	}
+
+	data := PageData{Items: result.Items, PerPage: params.PerPage}
+	var links []string
+	link := func(rel, param, value string) {
+		query := r.URL.Query()
+		query.Set(param, value)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+
+	if params.cursorMode {
+		data.NextCursor, data.PrevCursor = result.NextCursor, result.PrevCursor
+		if result.Total > 0 {
+			data.TotalItems = &result.Total
+		}
+		if result.PrevCursor != "" {
+			link("prev", "cursor", result.PrevCursor)
+		}
+		if result.NextCursor != "" {
+			link("next", "cursor", result.NextCursor)
+		}
+	} else {
+		totalPages := (result.Total + params.PerPage - 1) / params.PerPage
+		data.Page, data.TotalItems, data.TotalPages = params.Page, &result.Total, &totalPages
+
+		link("first", "page", "1")
+		if params.Page > 1 {
+			link("prev", "page", strconv.Itoa(params.Page-1))
+		}
+		if params.Page < totalPages {
+			link("next", "page", strconv.Itoa(params.Page+1))
+		}
+		if totalPages > 0 {
+			link("last", "page", strconv.Itoa(totalPages))
+		}
+	}
+
+	options := writeOptions(opts)
+	if options.Request == nil {
+		options.Request = r
+	}
+	if len(links) > 0 {
+		if options.Headers == nil {
+			options.Headers = make(http.Header)
+		} else {
+			options.Headers = options.Headers.Clone()
+		}
+		options.Headers.Set("Link", strings.Join(links, ", "))
+	}
+
+	return t.WriteJSON(w, http.StatusOK, JSONResponse{Data: data}, options)
+}
@@ -0,0 +1,180 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var listConfig = ListConfig{
+	AllowedSort:    []string{"name", "created_at"},
+	AllowedFilter:  []string{"status", "owner"},
+	DefaultSort:    "-created_at",
+	DefaultPerPage: 10,
+	MaxPerPage:     50,
+}
+
+var listParamsTests = []struct {
+	name    string
+	query   string
+	page    int
+	perPage int
+	sort    []SortField
+	filters map[string]string
+	invalid []string
+}{
+	{name: "defaults", query: "", page: 1, perPage: 10, sort: []SortField{{Field: "created_at", Descending: true}},
+		filters: map[string]string{}},
+	{name: "all params", query: "page=3&per_page=25&sort=name,-created_at&filter=status:active,owner:me", page: 3, perPage: 25,
+		sort:    []SortField{{Field: "name"}, {Field: "created_at", Descending: true}},
+		filters: map[string]string{"status": "active", "owner": "me"}},
+	{name: "bracket filters", query: "filter[status]=archived", page: 1, perPage: 10,
+		sort: []SortField{{Field: "created_at", Descending: true}}, filters: map[string]string{"status": "archived"}},
+	{name: "invalid", query: "page=0&per_page=500&sort=password&filter=secret:x,broken", invalid: []string{"filter", "page", "per_page", "sort"}},
+	{name: "not numbers", query: "page=two&per_page=ten", invalid: []string{"page", "per_page"}},
+	{name: "last page", query: "page=42949672", page: 42949672, perPage: 10,
+		sort: []SortField{{Field: "created_at", Descending: true}}, filters: map[string]string{}},
+	{name: "page too large", query: "page=42949673", invalid: []string{"page"}},
+}
+
+func TestTools_ReadListParams(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range listParamsTests {
+		request := httptest.NewRequest("GET", "/items?"+e.query, nil)
+		params, err := testTools.ReadListParams(request, listConfig)
+
+		if len(e.invalid) > 0 {
+			var queryErrors QueryErrors
+			if !errors.As(err, &queryErrors) {
+				t.Errorf("%s: expected QueryErrors but got %v", e.name, err)
+				continue
+			}
+			for _, param := range e.invalid {
+				if len(queryErrors[param]) == 0 {
+					t.Errorf("%s: expected %s to be rejected: %v", e.name, param, queryErrors)
+				}
+			}
+			if len(queryErrors) != len(e.invalid) {
+				t.Errorf("%s: unexpected errors %v", e.name, queryErrors)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			continue
+		}
+		if params.Page != e.page || params.PerPage != e.perPage {
+			t.Errorf("%s: expected page %d/%d but got %d/%d", e.name, e.page, e.perPage, params.Page, params.PerPage)
+		}
+		if !reflect.DeepEqual(params.Sort, e.sort) {
+			t.Errorf("%s: expected sort %v but got %v", e.name, e.sort, params.Sort)
+		}
+		if !reflect.DeepEqual(params.Filters, e.filters) {
+			t.Errorf("%s: expected filters %v but got %v", e.name, e.filters, params.Filters)
+		}
+	}
+}
+
+func TestTools_WritePageOffset(t *testing.T) {
+	var testTools Tools
+	request := httptest.NewRequest("GET", "/items?page=2&per_page=10&sort=name", nil)
+	params, err := testTools.ReadListParams(request, listConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Offset() != 10 {
+		t.Errorf("expected offset 10 but got %d", params.Offset())
+	}
+
+	responseRecorder := httptest.NewRecorder()
+	err = testTools.WritePage(responseRecorder, request, params, PageResult{Items: []string{"k", "l"}, Total: 35})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := responseRecorder.Header().Get("Link")
+	for _, expected := range []string{
+		`</items?page=1&per_page=10&sort=name>; rel="first"`,
+		`</items?page=1&per_page=10&sort=name>; rel="prev"`,
+		`</items?page=3&per_page=10&sort=name>; rel="next"`,
+		`</items?page=4&per_page=10&sort=name>; rel="last"`,
+	} {
+		if !strings.Contains(link, expected) {
+			t.Errorf("expected Link header to contain %s, got %s", expected, link)
+		}
+	}
+
+	var payload struct {
+		Data PageData `json:"data"`
+	}
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Data.Page != 2 || *payload.Data.TotalItems != 35 || *payload.Data.TotalPages != 4 {
+		t.Errorf("unexpected page data %+v", payload.Data)
+	}
+}
+
+func TestTools_WritePageInvalidParams(t *testing.T) {
+	var testTools Tools
+	request := httptest.NewRequest("GET", "/items", nil)
+
+	for _, params := range []*ListParams{nil, {Page: 1}} {
+		if err := testTools.WritePage(httptest.NewRecorder(), request, params, PageResult{Total: 5}); err == nil {
+			t.Errorf("expected params %+v to be refused", params)
+		}
+	}
+}
+
+func TestTools_WritePageCursor(t *testing.T) {
+	var testTools Tools
+	config := listConfig
+	config.Cursor = true
+
+	cursor, err := EncodeCursor(map[string]int{"after_id": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("GET", "/items?cursor="+cursor, nil)
+	params, err := testTools.ReadListParams(request, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var position struct {
+		AfterID int `json:"after_id"`
+	}
+	if err := params.DecodeCursor(&position); err != nil || position.AfterID != 42 {
+		t.Errorf("expected cursor to decode to 42, got %d (%v)", position.AfterID, err)
+	}
+
+	next, _ := EncodeCursor(map[string]int{"after_id": 52})
+	responseRecorder := httptest.NewRecorder()
+	err = testTools.WritePage(responseRecorder, request, params, PageResult{Items: []int{43, 52}, NextCursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link := responseRecorder.Header().Get("Link"); link != `</items?cursor=`+next+`>; rel="next"` {
+		t.Errorf("unexpected Link header %s", link)
+	}
+	if strings.Contains(responseRecorder.Body.String(), "total_items") {
+		t.Error("did not expect totals for cursor pagination without a total")
+	}
+
+	bad := &ListParams{Cursor: "!!!"}
+	if err := bad.DecodeCursor(&position); err == nil {
+		t.Error("expected an invalid cursor to be rejected")
+	}
+
+	request = httptest.NewRequest("GET", "/items?page=2", nil)
+	if _, err := testTools.ReadListParams(request, config); err == nil {
+		t.Error("expected page to be rejected in cursor mode")
+	}
+}
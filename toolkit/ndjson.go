@@ -0,0 +1,227 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// NDJSONRecordError reports a newline-delimited JSON record that could not be
+// read. The reader stays usable, so callers may skip the record and carry on.
+type NDJSONRecordError struct {
+	Record int
+	Err    error
+}
+
+func (e *NDJSONRecordError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.Record, e.Err)
+}
+
+func (e *NDJSONRecordError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NDJSONRecordError) ErrorData() interface{} {
+	data := map[string]interface{}{"record": e.Record}
+	var provider errorDataProvider
+	if errors.As(e.Err, &provider) {
+		data["error"] = provider.ErrorData()
+	} else {
+		data["error"] = e.Err.Error()
+	}
+
+	return data
+}
+
+// NDJSONTooManyRecordsError is returned once a stream holds more records than
+// MaxNDJSONRecords.
+type NDJSONTooManyRecordsError struct {
+	Limit int
+}
+
+func (e *NDJSONTooManyRecordsError) Error() string {
+	return fmt.Sprintf("body must not contain more than %d records", e.Limit)
+}
+
+func (e *NDJSONTooManyRecordsError) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// NDJSONReader reads newline-delimited JSON records from a request body one
+// at a time. Each record is limited to MaxJSONSize bytes and decoded with the
+// same rules as ReadJson. Compressed bodies are decompressed as they are read.
+type NDJSONReader struct {
+	// Schema validates each record. It defaults to Tools.JSONSchema; set it
+	// before the first call to Next to use another, or nil for none.
+	Schema *Schema
+
+	tools      *Tools
+	reader     *bufio.Reader
+	err        error
+	maxBytes   int
+	maxRecords int
+	records    int
+}
+
+// NewNDJSONReader returns a reader over the body of r.
+func (t *Tools) NewNDJSONReader(r *http.Request) *NDJSONReader {
+	maxBytes := 1024 * 1024
+	if t.MaxJSONSize != 0 {
+		maxBytes = t.MaxJSONSize
+	}
+
+	body, err := decodedBody(r)
+	if err != nil {
+		body = r.Body
+	}
+
+	return &NDJSONReader{
+		tools:      t,
+		reader:     bufio.NewReader(body),
+		err:        err,
+		maxBytes:   maxBytes,
+		maxRecords: t.MaxNDJSONRecords,
+		Schema:     t.JSONSchema,
+	}
+}
+
+// Records returns how many records have been read so far, including ones that
+// failed to decode.
+func (n *NDJSONReader) Records() int {
+	return n.records
+}
+
+// Next decodes the next record into data. It returns io.EOF when the stream is
+// exhausted and an *NDJSONRecordError for a record that cannot be decoded.
+// Blank lines are skipped.
+func (n *NDJSONReader) Next(data interface{}) error {
+	if n.err != nil {
+		return n.err
+	}
+
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+
+	if n.maxRecords > 0 && n.records >= n.maxRecords {
+		return &NDJSONTooManyRecordsError{Limit: n.maxRecords}
+	}
+	n.records++
+
+	if line == nil {
+		return &NDJSONRecordError{Record: n.records, Err: &JSONTooLargeError{Limit: int64(n.maxBytes)}}
+	}
+
+	if err := n.tools.decodeJSON(line, data, n.Schema); err != nil {
+		return &NDJSONRecordError{Record: n.records, Err: err}
+	}
+
+	return nil
+}
+
+// readLine returns the next non-blank line. A line longer than maxBytes is
+// discarded and reported as a nil slice.
+func (n *NDJSONReader) readLine() ([]byte, error) {
+	for {
+		var line []byte
+		tooLarge := false
+		for {
+			chunk, err := n.reader.ReadSlice('\n')
+			if !tooLarge {
+				line = append(line, chunk...)
+				if len(bytes.TrimRight(line, "\r\n")) > n.maxBytes {
+					tooLarge, line = true, nil
+				}
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && (tooLarge || len(line) > 0) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		if tooLarge {
+			return nil, nil
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// NDJSONWriter writes values as newline-delimited JSON, flushing each record to
+// the client as soon as it is written.
+type NDJSONWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewNDJSONWriter sets the NDJSON content type, writes status and headers, and
+// returns a writer for the records that follow. Only the header options apply
+// to a stream.
+func (t *Tools) NewNDJSONWriter(w http.ResponseWriter, status int, opts ...WriteOptions) *NDJSONWriter {
+	applyHeaders(w, writeOptions(opts))
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(status)
+
+	flusher, _ := w.(http.Flusher)
+	return &NDJSONWriter{w: w, flusher: flusher}
+}
+
+// Write encodes one record and flushes it.
+func (n *NDJSONWriter) Write(data interface{}) error {
+	out, err := marshalRedacted(data)
+	if err != nil {
+		return err
+	}
+	if _, err := n.w.Write(append(out, '\n')); err != nil {
+		return err
+	}
+	if n.flusher != nil {
+		n.flusher.Flush()
+	}
+
+	return nil
+}
+
+// WriteNDJSON writes every value received from values until the channel is
+// closed.
+func (t *Tools) WriteNDJSON(w http.ResponseWriter, status int, values <-chan interface{}, opts ...WriteOptions) error {
+	return t.WriteNDJSONFrom(w, status, func() (interface{}, error) {
+		value, ok := <-values
+		if !ok {
+			return nil, io.EOF
+		}
+		return value, nil
+	}, opts...)
+}
+
+// WriteNDJSONFrom writes the values returned by next until it returns io.EOF.
+// Any other error from next stops the stream and is returned.
+func (t *Tools) WriteNDJSONFrom(w http.ResponseWriter, status int, next func() (interface{}, error), opts ...WriteOptions) error {
+	writer := t.NewNDJSONWriter(w, status, opts...)
+	for {
+		value, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := writer.Write(value); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ndjsonTestRecord struct {
+	Foo string `json:"foo"`
+}
+
+func TestTools_NDJSONReader(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 32, MaxNDJSONRecords: 4}
+	body := "{\"foo\": \"a\"}\n\n{\"foo\": 1}\r\n{\"foo\": \"" + strings.Repeat("x", 40) + "\"}\n{\"foo\": \"d\"}\n{\"foo\": \"e\"}\n"
+	request := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	reader := testTools.NewNDJSONReader(request)
+
+	var foos []string
+	var failed []int
+	for {
+		var record ndjsonTestRecord
+		err := reader.Next(&record)
+		if err == io.EOF {
+			t.Fatal("expected record limit to be reached before EOF")
+		}
+
+		var recordError *NDJSONRecordError
+		var tooMany *NDJSONTooManyRecordsError
+		if errors.As(err, &tooMany) {
+			break
+		}
+		if errors.As(err, &recordError) {
+			failed = append(failed, recordError.Record)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		foos = append(foos, record.Foo)
+	}
+
+	if strings.Join(foos, ",") != "a,d" {
+		t.Errorf("unexpected records read: %v", foos)
+	}
+	if len(failed) != 2 || failed[0] != 2 || failed[1] != 3 {
+		t.Errorf("unexpected failed records: %v", failed)
+	}
+	if reader.Records() != 4 {
+		t.Errorf("expected 4 records counted but got %d", reader.Records())
+	}
+}
+
+func TestTools_NDJSONReaderRecordErrors(t *testing.T) {
+	var testTools Tools
+	request := httptest.NewRequest("POST", "/", strings.NewReader("{\"foo\": \"a\"}\n{\"bar\": \"b\"}"))
+	reader := testTools.NewNDJSONReader(request)
+
+	var record ndjsonTestRecord
+	if err := reader.Next(&record); err != nil {
+		t.Fatal(err)
+	}
+
+	err := reader.Next(&record)
+	var unknown *JSONUnknownFieldError
+	if !errors.As(err, &unknown) || unknown.Path != "bar" {
+		t.Errorf("expected unknown field error for record 2, got %v", err)
+	}
+
+	if err := reader.Next(&record); err != io.EOF {
+		t.Errorf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestTools_WriteNDJSON(t *testing.T) {
+	var testTools Tools
+	responseRecorder := httptest.NewRecorder()
+
+	values := make(chan interface{})
+	go func() {
+		defer close(values)
+		for _, foo := range []string{"a", "b", "c"} {
+			values <- ndjsonTestRecord{Foo: foo}
+		}
+	}()
+
+	err := testTools.WriteNDJSON(responseRecorder, http.StatusOK, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := responseRecorder.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("wrong content type %s", ct)
+	}
+	if !responseRecorder.Flushed {
+		t.Error("expected records to be flushed")
+	}
+
+	scanner := bufio.NewScanner(responseRecorder.Body)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if strings.Join(lines, "|") != `{"foo":"a"}|{"foo":"b"}|{"foo":"c"}` {
+		t.Errorf("unexpected body: %v", lines)
+	}
+}
+
+func TestTools_WriteNDJSONFromError(t *testing.T) {
+	var testTools Tools
+	responseRecorder := httptest.NewRecorder()
+
+	sent := 0
+	err := testTools.WriteNDJSONFrom(responseRecorder, http.StatusOK, func() (interface{}, error) {
+		if sent == 2 {
+			return nil, errors.New("database went away")
+		}
+		sent++
+		return ndjsonTestRecord{Foo: "x"}, nil
+	})
+
+	if err == nil || err.Error() != "database went away" {
+		t.Errorf("expected iterator error to be returned, got %v", err)
+	}
+	if strings.Count(responseRecorder.Body.String(), "\n") != 2 {
+		t.Errorf("expected two records before the error, got %q", responseRecorder.Body.String())
+	}
+}
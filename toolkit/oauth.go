@@ -0,0 +1,231 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken string
+	TokenType   string
+	// Expiry is zero for tokens that do not expire.
+	Expiry time.Time
+}
+
+// TokenSource supplies the bearer tokens JSONClient sends in the
+// Authorization header. Implementations must be safe for concurrent use.
+type TokenSource interface {
+	// Token returns a valid token, obtaining a new one when needed.
+	Token(ctx context.Context) (*Token, error)
+	// Invalidate discards token, after the server rejected it, so that the
+	// next call to Token obtains a new one.
+	Invalidate(token *Token)
+}
+
+// TokenError is returned when a token endpoint refuses to issue a token.
+// Code and Description come from the RFC 6749 error response, if any.
+type TokenError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("token endpoint responded with status %d", e.Status)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("token endpoint responded with status %d: %s", e.Status, e.Code)
+	}
+	return fmt.Sprintf("token endpoint responded with status %d: %s: %s", e.Status, e.Code, e.Description)
+}
+
+// ClientCredentials is a TokenSource using the OAuth2 client credentials
+// grant. Tokens are cached and shared by every goroutine until shortly
+// before they expire; concurrent callers wait for a single token request.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are added to the token request, e.g. an audience.
+	EndpointParams url.Values
+	// AuthInParams sends the client credentials in the request body rather
+	// than with HTTP Basic authentication.
+	AuthInParams bool
+	// ExpiryDelta is how long before expiry a token is replaced. It
+	// defaults to 30 seconds.
+	ExpiryDelta time.Duration
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	token    *Token
+	fetching *tokenFetch
+}
+
+// tokenFetch is a token request in progress. done is closed once token or
+// err is set.
+type tokenFetch struct {
+	done  chan struct{}
+	token *Token
+	err   error
+	// cancelled reports that err came from the context of the caller that
+	// made the request.
+	cancelled bool
+}
+
+// Token returns the cached token, or requests a new one if there is none or
+// it is about to expire. Callers arriving while a token is being requested
+// wait for it until their context is done; if the caller making the request
+// gives up, one of them requests the token again.
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	delta := c.ExpiryDelta
+	if delta == 0 {
+		delta = 30 * time.Second
+	}
+
+	for {
+		c.mu.Lock()
+		if c.token != nil && (c.token.Expiry.IsZero() || time.Until(c.token.Expiry) > delta) {
+			token := c.token
+			c.mu.Unlock()
+			return token, nil
+		}
+
+		if f := c.fetching; f != nil {
+			c.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if f.err == nil {
+				return f.token, nil
+			}
+			if !f.cancelled || ctx.Err() != nil {
+				return nil, f.err
+			}
+			continue
+		}
+
+		f := &tokenFetch{done: make(chan struct{})}
+		c.fetching = f
+		c.mu.Unlock()
+
+		f.token, f.err = c.fetch(ctx)
+		f.cancelled = f.err != nil && ctx.Err() != nil
+
+		c.mu.Lock()
+		if f.err == nil {
+			c.token = f.token
+		}
+		c.fetching = nil
+		c.mu.Unlock()
+		close(f.done)
+
+		return f.token, f.err
+	}
+}
+
+// Invalidate discards token if it is still the cached one.
+func (c *ClientCredentials) Invalidate(token *Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = nil
+	}
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for key, values := range c.EndpointParams {
+		form[key] = values
+	}
+	if c.AuthInParams {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if !c.AuthInParams {
+		request.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	decodeErr := json.Unmarshal(body, &payload)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 || payload.Error != "" {
+		return nil, &TokenError{Status: response.StatusCode, Code: payload.Error, Description: payload.ErrorDescription}
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("token response is not valid JSON: %w", decodeErr)
+	}
+	if payload.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	token := &Token{AccessToken: payload.AccessToken, TokenType: payload.TokenType}
+	// Some servers send expires_in as a string.
+	if seconds, err := strconv.ParseInt(strings.Trim(string(payload.ExpiresIn), `"`), 10, 64); err == nil && seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+
+	return token, nil
+}
+
+// authorize sets the Authorization header of request from the client's
+// token source and returns the token used.
+func (c *JSONClient) authorize(request *http.Request) (*Token, error) {
+	token, err := c.TokenSource.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	request.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+
+	return token, nil
+}
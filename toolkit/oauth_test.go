@@ -0,0 +1,187 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTokenServer issues tokens tok-1, tok-2, ... to the client "id" with
+// secret "secret".
+func newTokenServer(expiresIn string) (*httptest.Server, *int32) {
+	var issued int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"bearer","expires_in":%s,"scope":"%s"}`, n, expiresIn, r.FormValue("scope"))
+	}))
+
+	return srv, &issued
+}
+
+func TestJSONClient_ClientCredentials(t *testing.T) {
+	tokenServer, issued := newTokenServer("3600")
+	defer tokenServer.Close()
+
+	var mu sync.Mutex
+	valid := "tok-1"
+	var unauthorized int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.Header.Get("Authorization") == "Bearer "+valid
+		mu.Unlock()
+		if !ok {
+			atomic.AddInt32(&unauthorized, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+
+	var testTools Tools
+	client := testTools.NewJSONClient(api.URL)
+	client.TokenSource = &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "secret", Scopes: []string{"orders"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Get(context.Background(), "/", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(issued) != 1 {
+		t.Fatalf("expected one token to be shared, %d were issued", *issued)
+	}
+
+	// A revoked token is replaced once.
+	mu.Lock()
+	valid = "tok-2"
+	mu.Unlock()
+	attempts := 0
+	if _, err := client.Get(context.Background(), "/", nil, RequestOptions{Attempts: &attempts}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(issued) != 2 || attempts != 2 {
+		t.Errorf("expected a new token after a 401, got %d tokens and %d attempts", *issued, attempts)
+	}
+
+	// A server that keeps refusing is not retried forever.
+	mu.Lock()
+	valid = "never"
+	mu.Unlock()
+	atomic.StoreInt32(&unauthorized, 0)
+	var remoteErr *RemoteError
+	if _, err := client.Get(context.Background(), "/", nil); !errors.As(err, &remoteErr) || remoteErr.Status != http.StatusUnauthorized {
+		t.Errorf("expected a 401 RemoteError, got %v", err)
+	}
+	if atomic.LoadInt32(&unauthorized) != 2 {
+		t.Errorf("expected exactly one refresh, got %d unauthorized responses", unauthorized)
+	}
+}
+
+func TestClientCredentials_Token(t *testing.T) {
+	tokenServer, issued := newTokenServer(`"60"`)
+	defer tokenServer.Close()
+
+	source := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "secret", ExpiryDelta: 2 * time.Minute}
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Expiry.IsZero() || time.Until(first.Expiry) > time.Minute {
+		t.Errorf("expected a string expires_in to be parsed, got expiry %s", first.Expiry)
+	}
+
+	second, _ := source.Token(context.Background())
+	if second.AccessToken == first.AccessToken || *issued != 2 {
+		t.Errorf("expected a token within ExpiryDelta of expiring to be replaced")
+	}
+
+	source.ExpiryDelta = 0
+	source.Invalidate(first)
+	if third, _ := source.Token(context.Background()); third != second {
+		t.Errorf("expected invalidating a stale token to keep the current one")
+	}
+
+	wrong := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "wrong"}
+	_, err = wrong.Token(context.Background())
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.Code != "invalid_client" || tokenErr.Status != http.StatusUnauthorized {
+		t.Errorf("expected an invalid_client TokenError, got %v", err)
+	}
+}
+
+func TestClientCredentials_TokenWaitHonoursContext(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"tok","token_type":"bearer"}`))
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	source := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "secret"}
+
+	// The first caller's request hangs until it gives up.
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := source.Token(firstCtx)
+		firstDone <- err
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := source.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a waiting caller to stop at its deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a waiting caller to give up promptly, waited %s", elapsed)
+	}
+
+	waiting := make(chan *Token, 1)
+	go func() {
+		token, _ := source.Token(context.Background())
+		waiting <- token
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancelFirst()
+
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the first caller to be cancelled, got %v", err)
+	}
+	if token := <-waiting; token == nil || token.AccessToken != "tok" {
+		t.Errorf("expected a waiting caller to request the token itself, got %v", token)
+	}
+}
@@ -0,0 +1,383 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxMessage is a JSON payload waiting to be delivered by an Outbox.
+type OutboxMessage struct {
+	ID          string          `json:"id"`
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Headers     http.Header     `json:"headers,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Outbox delivers JSON payloads asynchronously and durably. Messages are
+// stored as files in a pending directory until they are delivered, and moved
+// to a dead-letter directory when every attempt has failed. Each message is
+// sent with its ID as the idempotency key, as a message may be delivered
+// more than once if the process stops mid-delivery. Only one process may use
+// a directory at a time.
+type Outbox struct {
+	// Client delivers the messages.
+	Client *JSONClient
+	// Workers is the number of concurrent deliveries. It defaults to 4.
+	Workers int
+	// MaxAttempts defaults to 10. Failed attempts are retried after
+	// InitialBackoff (default 1 second), doubling up to MaxBackoff (default
+	// 5 minutes).
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PollInterval is how often the pending directory is scanned for
+	// messages that are due. It defaults to 1 second.
+	PollInterval time.Duration
+	// OnDeadLetter, when set, is called for every message moved to the
+	// dead-letter store.
+	OnDeadLetter func(message OutboxMessage)
+
+	pendingDir string
+	deadDir    string
+	wake       chan struct{}
+
+	mu      sync.Mutex
+	claimed map[string]bool
+}
+
+// NewOutbox returns an outbox storing its messages under dir, which is
+// created if it does not exist. Messages left pending by an earlier run are
+// delivered once Run is called.
+func (t *Tools) NewOutbox(dir string) (*Outbox, error) {
+	o := &Outbox{
+		Client:     t.NewJSONClient(""),
+		pendingDir: filepath.Join(dir, "pending"),
+		deadDir:    filepath.Join(dir, "dead"),
+		wake:       make(chan struct{}, 1),
+		claimed:    map[string]bool{},
+	}
+
+	for _, d := range []string{o.pendingDir, o.deadDir} {
+		if err := t.CreateDirectoryIfNotExist(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// Enqueue stores payload for delivery to uri with a POST request and returns
+// the message ID.
+func (o *Outbox) Enqueue(uri string, payload interface{}, headers ...http.Header) (string, error) {
+	return o.EnqueueRequest(http.MethodPost, uri, payload, headers...)
+}
+
+// EnqueueRequest stores payload for delivery to uri with the given method.
+func (o *Outbox) EnqueueRequest(method, uri string, payload interface{}, headers ...http.Header) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	message := OutboxMessage{
+		// IDs sort in the order messages were enqueued.
+		ID:          fmt.Sprintf("%019d-%s", now.UnixNano(), hex.EncodeToString(random)),
+		Method:      method,
+		URL:         uri,
+		Payload:     data,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if len(headers) > 0 {
+		message.Headers = headers[0]
+	}
+
+	if err := writeOutboxMessage(o.pendingDir, message); err != nil {
+		return "", err
+	}
+
+	o.notify()
+	return message.ID, nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Depth returns the number of messages waiting to be delivered.
+func (o *Outbox) Depth() (int, error) {
+	ids, err := outboxIDs(o.pendingDir)
+	return len(ids), err
+}
+
+// DeadLetters returns the messages whose delivery was abandoned, oldest
+// first.
+func (o *Outbox) DeadLetters() ([]OutboxMessage, error) {
+	ids, err := outboxIDs(o.deadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]OutboxMessage, 0, len(ids))
+	for _, id := range ids {
+		message, err := readOutboxMessage(o.deadDir, id)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// Replay moves the dead letter with the given ID back to the pending queue
+// with its attempts reset.
+func (o *Outbox) Replay(id string) error {
+	message, err := readOutboxMessage(o.deadDir, id)
+	if err != nil {
+		return err
+	}
+
+	message.Attempts, message.NextAttempt, message.LastError = 0, time.Now().UTC(), ""
+	if err := writeOutboxMessage(o.pendingDir, message); err != nil {
+		return err
+	}
+	if err := os.Remove(outboxPath(o.deadDir, id)); err != nil {
+		return err
+	}
+
+	o.notify()
+	return nil
+}
+
+// ReplayAll moves every dead letter back to the pending queue and returns
+// how many were moved.
+func (o *Outbox) ReplayAll() (int, error) {
+	ids, err := outboxIDs(o.deadDir)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := o.Replay(id); err != nil {
+			return i, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// Run delivers messages until ctx is cancelled, then waits for deliveries in
+// progress to finish.
+func (o *Outbox) Run(ctx context.Context) error {
+	workers, poll := o.Workers, o.PollInterval
+	if workers == 0 {
+		workers = 4
+	}
+	if poll == 0 {
+		poll = time.Second
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				o.deliver(ctx, id)
+				o.mu.Lock()
+				delete(o.claimed, id)
+				o.mu.Unlock()
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		ids, err := outboxIDs(o.pendingDir)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			o.mu.Lock()
+			claimed := o.claimed[id]
+			o.mu.Unlock()
+			if claimed {
+				continue
+			}
+
+			message, err := readOutboxMessage(o.pendingDir, id)
+			if err != nil || message.NextAttempt.After(time.Now()) {
+				continue
+			}
+
+			o.mu.Lock()
+			o.claimed[id] = true
+			o.mu.Unlock()
+
+			select {
+			case jobs <- id:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// deliver makes one delivery attempt and records its outcome.
+func (o *Outbox) deliver(ctx context.Context, id string) {
+	message, err := readOutboxMessage(o.pendingDir, id)
+	if err != nil {
+		return
+	}
+
+	_, err = o.Client.Do(ctx, message.Method, message.URL, message.Payload, nil, RequestOptions{Headers: message.Headers, IdempotencyKey: message.ID})
+	if err == nil {
+		_ = os.Remove(outboxPath(o.pendingDir, id))
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	maxAttempts := o.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 10
+	}
+	message.Attempts++
+	message.LastError = err.Error()
+
+	// Client errors other than timeouts and rate limiting will not succeed
+	// on a later attempt.
+	var remoteErr *RemoteError
+	permanent := errors.As(err, &remoteErr) && remoteErr.Status >= 400 && remoteErr.Status < 500 &&
+		remoteErr.Status != http.StatusRequestTimeout && remoteErr.Status != http.StatusTooManyRequests
+
+	if permanent || message.Attempts >= maxAttempts {
+		if writeOutboxMessage(o.deadDir, message) == nil {
+			_ = os.Remove(outboxPath(o.pendingDir, id))
+			if o.OnDeadLetter != nil {
+				o.OnDeadLetter(message)
+			}
+		}
+		return
+	}
+
+	initial, maximum := o.InitialBackoff, o.MaxBackoff
+	if initial == 0 {
+		initial = time.Second
+	}
+	if maximum == 0 {
+		maximum = 5 * time.Minute
+	}
+	policy := RetryPolicy{InitialBackoff: initial, MaxBackoff: maximum}
+	message.NextAttempt = time.Now().UTC().Add(policy.backoff(message.Attempts))
+	_ = writeOutboxMessage(o.pendingDir, message)
+}
+
+func outboxPath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// outboxIDs lists the IDs of the messages in dir in the order they were
+// enqueued.
+func outboxIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".json") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func readOutboxMessage(dir, id string) (OutboxMessage, error) {
+	var message OutboxMessage
+	if strings.ContainsAny(id, `/\`) {
+		return message, fmt.Errorf("invalid outbox message ID %q", id)
+	}
+
+	data, err := os.ReadFile(outboxPath(dir, id))
+	if err != nil {
+		return message, err
+	}
+	err = json.Unmarshal(data, &message)
+
+	return message, err
+}
+
+// writeOutboxMessage stores message atomically, so that a crash never leaves
+// a partly written file behind.
+func writeOutboxMessage(dir string, message OutboxMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), outboxPath(dir, message.ID))
+}
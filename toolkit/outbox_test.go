@@ -0,0 +1,157 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestOutbox(t *testing.T, dir string) *Outbox {
+	var testTools Tools
+	outbox, err := testTools.NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.InitialBackoff, outbox.MaxBackoff, outbox.PollInterval = time.Millisecond, 5*time.Millisecond, 10*time.Millisecond
+
+	return outbox
+}
+
+func TestOutbox_Delivery(t *testing.T) {
+	var failures int32 = 2
+	var mu sync.Mutex
+	var received []string
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, string(body))
+		keys = append(keys, r.Header.Get("Idempotency-Key")+r.Header.Get("X-Source"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	// Messages enqueued before the outbox runs survive a restart.
+	first := newTestOutbox(t, dir)
+	id, err := first.Enqueue(srv.URL, map[string]int{"order": 1}, http.Header{"X-Source": {"-test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := first.Depth(); depth != 1 {
+		t.Fatalf("expected a queue depth of 1, got %d", depth)
+	}
+
+	outbox := newTestOutbox(t, dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- outbox.Run(ctx) }()
+
+	waitFor(t, "delivery", func() bool {
+		depth, _ := outbox.Depth()
+		return depth == 0
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0] != `{"order":1}` || keys[0] != id+"-test" {
+		t.Errorf("expected one delivery with the message ID as idempotency key, got %v %v", received, keys)
+	}
+}
+
+func TestOutbox_DeadLetterAndReplay(t *testing.T) {
+	var healthy int32
+	var delivered int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer srv.Close()
+
+	outbox := newTestOutbox(t, t.TempDir())
+	outbox.MaxAttempts = 2
+	var deadLettered int32
+	outbox.OnDeadLetter = func(message OutboxMessage) { atomic.AddInt32(&deadLettered, 1) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = outbox.Run(ctx) }()
+
+	id, err := outbox.Enqueue(srv.URL, json.RawMessage(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "dead letter", func() bool { return atomic.LoadInt32(&deadLettered) == 1 })
+
+	dead, err := outbox.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != id || dead[0].Attempts != 2 || dead[0].LastError == "" {
+		t.Fatalf("unexpected dead letters %+v", dead)
+	}
+	if depth, _ := outbox.Depth(); depth != 0 {
+		t.Errorf("expected an empty queue, got %d", depth)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	if n, err := outbox.ReplayAll(); err != nil || n != 1 {
+		t.Fatalf("expected one message replayed, got %d %v", n, err)
+	}
+
+	waitFor(t, "replayed delivery", func() bool { return atomic.LoadInt32(&delivered) == 1 })
+	if dead, _ := outbox.DeadLetters(); len(dead) != 0 {
+		t.Errorf("expected no dead letters after replay, got %d", len(dead))
+	}
+}
+
+func TestOutbox_PermanentFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer srv.Close()
+
+	outbox := newTestOutbox(t, t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = outbox.Run(ctx) }()
+
+	if _, err := outbox.Enqueue(srv.URL, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "dead letter", func() bool {
+		dead, _ := outbox.DeadLetters()
+		return len(dead) == 1 && dead[0].Attempts == 1
+	})
+}
@@ -0,0 +1,548 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrPatchTestFailed is wrapped by a *JSONPatchError when a test operation
+// finds a value other than the one expected.
+var ErrPatchTestFailed = errors.New("test operation failed")
+
+// JSONPatchOperation is one operation of an RFC 6902 JSON Patch document.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatchError reports the operation of a JSON Patch that could not be
+// applied. Index is the zero-based position of the operation in the patch.
+type JSONPatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *JSONPatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *JSONPatchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *JSONPatchError) StatusCode() int {
+	if errors.Is(e.Err, ErrPatchTestFailed) {
+		return http.StatusConflict
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+func (e *JSONPatchError) ErrorData() interface{} {
+	return map[string]interface{}{"index": e.Index, "op": e.Op, "path": e.Path, "reason": e.Err.Error()}
+}
+
+// ReadMergePatch reads an RFC 7396 JSON Merge Patch from the request body,
+// following the same size and strictness rules as ReadJson, and applies it to
+// target, which must be a non-nil pointer. The duplicate key, UTF-8, depth and
+// array length limits apply to the patch; JSONSchema does not, as it
+// describes whole request bodies.
+func (t *Tools) ReadMergePatch(w http.ResponseWriter, r *http.Request, target interface{}) error {
+	body, err := t.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	return t.ApplyMergePatch(target, body)
+}
+
+// ReadJSONPatch reads an RFC 6902 JSON Patch document from the request body,
+// following the same size and strictness rules as ReadMergePatch, and applies
+// it to target, which must be a non-nil pointer. Operations are applied in
+// order; if any fails, target is left untouched.
+func (t *Tools) ReadJSONPatch(w http.ResponseWriter, r *http.Request, target interface{}) error {
+	body, err := t.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	return t.ApplyJSONPatch(target, body)
+}
+
+// ApplyMergePatch applies an RFC 7396 merge patch to target.
+func (t *Tools) ApplyMergePatch(target interface{}, patch []byte) error {
+	var patchDocument interface{}
+	if err := t.decodePatch(patch, &patchDocument); err != nil {
+		return err
+	}
+
+	document, err := toJSONDocument(target)
+	if err != nil {
+		return err
+	}
+
+	return t.replaceFromDocument(target, mergePatch(document, patchDocument))
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to target.
+func (t *Tools) ApplyJSONPatch(target interface{}, patch []byte) error {
+	var operations []JSONPatchOperation
+	if err := t.decodePatch(patch, &operations); err != nil {
+		return err
+	}
+
+	document, err := toJSONDocument(target)
+	if err != nil {
+		return err
+	}
+
+	for i, operation := range operations {
+		document, err = applyPatchOperation(document, operation)
+		if err != nil {
+			return &JSONPatchError{Index: i, Op: operation.Op, Path: operation.Path, Err: err}
+		}
+	}
+
+	return t.replaceFromDocument(target, document)
+}
+
+// decodePatch decodes a patch body after checking it against the structure
+// limits.
+func (t *Tools) decodePatch(body []byte, data interface{}) error {
+	if err := t.checkJSONStructure(body, data); err != nil {
+		return err
+	}
+
+	return decodeSingleJSON(body, data)
+}
+
+// decodeSingleJSON strictly decodes one JSON value, keeping numbers exact.
+func decodeSingleJSON(body []byte, data interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(data); err != nil {
+		return translateJSONError(err, body, data)
+	}
+
+	offset := decoder.InputOffset()
+	if decoder.More() {
+		offset += int64(len(body[offset:]) - len(bytes.TrimLeft(body[offset:], " \t\r\n")))
+		line, column := lineColumn(body, offset+1)
+		return &JSONMultipleValuesError{Offset: offset, Line: line, Column: column}
+	}
+
+	return nil
+}
+
+// toJSONDocument converts a Go value into its generic JSON form.
+func toJSONDocument(value interface{}) (interface{}, error) {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// replaceFromDocument decodes document into a copy of target whose JSON
+// fields have been cleared, so removed members are zeroed while fields JSON
+// does not see, such as unexported and `json:"-"` fields, keep their values,
+// and stores the copy in target. The document is the patched resource rather
+// than a request body, so only the unknown field, number and validation
+// settings apply to it.
+func (t *Tools) replaceFromDocument(target interface{}, document interface{}) error {
+	pointer := reflect.ValueOf(target)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(target)}
+	}
+
+	out, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	patched := reflect.New(pointer.Elem().Type())
+	patched.Elem().Set(pointer.Elem())
+	clearJSONFields(patched.Elem())
+
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	if !t.AllowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if t.UseNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(patched.Interface()); err != nil {
+		return translateJSONError(err, out, patched.Interface())
+	}
+	if t.ValidateJSON {
+		if err := t.Validate(patched.Interface()); err != nil {
+			return err
+		}
+	}
+	pointer.Elem().Set(patched.Elem())
+
+	return nil
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// clearJSONFields zeroes what encoding/json decodes into v. Structs are
+// cleared field by field, leaving the fields it does not see; anything else,
+// including structs that decode themselves, is zeroed. Maps, slices and
+// pointers are zeroed rather than cleared, so values shared with the
+// original target are never modified.
+func clearJSONFields(v reflect.Value) {
+	typ := v.Type()
+	if typ.Kind() != reflect.Struct || reflect.PointerTo(typ).Implements(jsonUnmarshalerType) || reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		v.Set(reflect.Zero(typ))
+		return
+	}
+
+	for _, field := range jsonFields(typ) {
+		if field.index == nil {
+			continue
+		}
+		if f, ok := promotedField(v, field.index); ok {
+			clearJSONFields(f)
+		}
+	}
+}
+
+// promotedField follows index through v, replacing embedded pointers by
+// pointers to copies so that their structs can be cleared. It reports false
+// if the field cannot be set, as behind a nil or unexported embedded pointer.
+func promotedField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() || !v.CanSet() {
+				return reflect.Value{}, false
+			}
+			clone := reflect.New(v.Type().Elem())
+			clone.Elem().Set(v.Elem())
+			v.Set(clone)
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, v.CanSet()
+}
+
+// mergePatch implements the MergePatch function of RFC 7396.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+
+	return targetObject
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses a JSON Pointer token as an index into an array of length
+// size. "-" is only allowed when allowEnd is set and means one past the end.
+func arrayIndex(token string, size int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return size, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	limit := size - 1
+	if allowEnd {
+		limit = size
+	}
+	if index > limit {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+
+	return index, nil
+}
+
+// getPointer returns the value at pointer in document.
+func getPointer(document interface{}, pointer string) (interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := document
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// updatePointer calls change on the container holding the last token of
+// pointer and returns the updated document. change returns the container's
+// replacement, which matters for arrays that grow or shrink.
+func updatePointer(document interface{}, tokens []string, pointer string, change func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return change(document, tokens[0])
+	}
+
+	switch node := document.(type) {
+	case map[string]interface{}:
+		child, ok := node[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+		updated, err := updatePointer(child, tokens[1:], pointer, change)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = updated
+		return node, nil
+	case []interface{}:
+		index, err := arrayIndex(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updatePointer(node[index], tokens[1:], pointer, change)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("path %q does not exist", pointer)
+}
+
+func addPointer(document interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return updatePointer(document, tokens, pointer, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path %q does not exist", pointer)
+	})
+}
+
+func removePointer(document interface{}, pointer string) (interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	return updatePointer(document, tokens, pointer, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("path %q does not exist", pointer)
+	})
+}
+
+func applyPatchOperation(document interface{}, operation JSONPatchOperation) (interface{}, error) {
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, errors.New("value is required")
+		}
+		decoder := json.NewDecoder(bytes.NewReader(operation.Value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return addPointer(document, operation.Path, value)
+
+	case "remove":
+		return removePointer(document, operation.Path)
+
+	case "replace":
+		if _, err := getPointer(document, operation.Path); err != nil {
+			return nil, err
+		}
+		document, err := removePointer(document, operation.Path)
+		if err != nil && operation.Path != "" {
+			return nil, err
+		}
+		return addPointer(document, operation.Path, value)
+
+	case "move":
+		if strings.HasPrefix(operation.Path+"/", operation.From+"/") && operation.Path != operation.From {
+			return nil, fmt.Errorf("cannot move %q into one of its own children", operation.From)
+		}
+		moved, err := getPointer(document, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if document, err = removePointer(document, operation.From); err != nil {
+			return nil, err
+		}
+		return addPointer(document, operation.Path, moved)
+
+	case "copy":
+		copied, err := getPointer(document, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if copied, err = toJSONDocument(copied); err != nil {
+			return nil, err
+		}
+		return addPointer(document, operation.Path, copied)
+
+	case "test":
+		actual, err := getPointer(document, operation.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(actual, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return document, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", operation.Op)
+}
+
+// jsonEqual compares two generic JSON values, treating numbers as equal when
+// they have the same numeric value.
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if av == bv {
+			return true
+		}
+		ar, aOK := new(big.Rat).SetString(av.String())
+		br, bOK := new(big.Rat).SetString(bv.String())
+		return aOK && bOK && ar.Cmp(br) == 0
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			other, ok := bv[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a == b
+}
@@ -0,0 +1,217 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type patchTestAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type patchTestAudit struct {
+	CreatedBy string `json:"created_by"`
+	version   int
+}
+
+type patchTestUser struct {
+	Name    string            `json:"name"`
+	Age     int64             `json:"age"`
+	Tags    []string          `json:"tags"`
+	Address *patchTestAddress `json:"address,omitempty"`
+	Hash    string            `json:"-"`
+	id      int
+	patchTestAudit
+}
+
+// newPatchTestUser returns a user whose Hash, id and version fields, which
+// JSON does not see, must survive every patch.
+func newPatchTestUser() patchTestUser {
+	return patchTestUser{
+		Name:           "Jack",
+		Age:            9007199254740993,
+		Tags:           []string{"a", "b"},
+		Address:        &patchTestAddress{City: "Istanbul", Zip: "34000"},
+		Hash:           "hash",
+		id:             7,
+		patchTestAudit: patchTestAudit{CreatedBy: "admin", version: 3},
+	}
+}
+
+var mergePatchTests = []struct {
+	name          string
+	patch         string
+	expected      func(u *patchTestUser)
+	errorExpected bool
+}{
+	{name: "replace scalar", patch: `{"name": "Jill"}`, expected: func(u *patchTestUser) { u.Name = "Jill" }},
+	{name: "nested merge", patch: `{"address": {"zip": null}}`, expected: func(u *patchTestUser) { u.Address.Zip = "" }},
+	{name: "remove object", patch: `{"address": null}`, expected: func(u *patchTestUser) { u.Address = nil }},
+	{name: "replace array", patch: `{"tags": ["c"]}`, expected: func(u *patchTestUser) { u.Tags = []string{"c"} }},
+	{name: "unknown field", patch: `{"nickname": "J"}`, errorExpected: true},
+	{name: "wrong type", patch: `{"age": "old"}`, errorExpected: true},
+	{name: "two values", patch: `{"name": "a"} {"name": "b"}`, errorExpected: true},
+}
+
+func TestTools_ReadMergePatch(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range mergePatchTests {
+		user := newPatchTestUser()
+		request := httptest.NewRequest("PATCH", "/", strings.NewReader(e.patch))
+		err := testTools.ReadMergePatch(httptest.NewRecorder(), request, &user)
+
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: error expected, but none received", e.name)
+			}
+			if !reflect.DeepEqual(user, newPatchTestUser()) {
+				t.Errorf("%s: target modified despite error", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			continue
+		}
+
+		expected := newPatchTestUser()
+		e.expected(&expected)
+		if !reflect.DeepEqual(user, expected) {
+			t.Errorf("%s: expected %+v but got %+v", e.name, expected, user)
+		}
+	}
+}
+
+func TestTools_ApplyMergePatch_Limits(t *testing.T) {
+	schema, err := LoadSchema([]byte(`{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name          string
+		tools         Tools
+		patch         string
+		errorExpected bool
+	}{
+		{"duplicate key", Tools{DisallowDuplicateKeys: true}, `{"name": "x", "name": "y"}`, true},
+		{"invalid utf-8", Tools{RejectInvalidUTF8: true}, "{\"name\": \"\xff\"}", true},
+		{"deep patch", Tools{MaxJSONDepth: 1}, `{"address": {"city": "Izmir"}}`, true},
+		{"shallow patch of a deep resource", Tools{MaxJSONDepth: 1}, `{"name": "Jill"}`, false},
+		{"schema describes request bodies", Tools{JSONSchema: schema}, `{"name": "Jill"}`, false},
+	}
+
+	for _, e := range tests {
+		user := newPatchTestUser()
+		err := e.tools.ApplyMergePatch(&user, []byte(e.patch))
+		if (err != nil) != e.errorExpected {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if e.errorExpected && !reflect.DeepEqual(user, newPatchTestUser()) {
+			t.Errorf("%s: target modified despite error", e.name)
+		}
+	}
+
+	user := newPatchTestUser()
+	strict := Tools{DisallowDuplicateKeys: true}
+	err = strict.ApplyJSONPatch(&user, []byte(`[{"op": "replace", "path": "/name", "Op": "remove", "value": "x"}]`))
+	if err == nil {
+		t.Error("expected a JSON Patch with duplicate keys to be rejected")
+	}
+}
+
+func TestTools_ApplyMergePatch_SharedValues(t *testing.T) {
+	var testTools Tools
+	original := newPatchTestUser()
+	user := original
+
+	if err := testTools.ApplyMergePatch(&user, []byte(`{"address": {"zip": null}, "created_by": null}`)); err != nil {
+		t.Fatal(err)
+	}
+	if user.Address.Zip != "" || user.CreatedBy != "" || user.version != 3 || user.Hash != "hash" {
+		t.Errorf("unexpected patched user %+v", user)
+	}
+	if original.Address.Zip != "34000" {
+		t.Error("expected the address shared with the original not to be modified")
+	}
+}
+
+var jsonPatchTests = []struct {
+	name     string
+	patch    string
+	expected func(u *patchTestUser)
+	status   int
+	index    int
+}{
+	{name: "replace", patch: `[{"op": "replace", "path": "/name", "value": "Jill"}]`,
+		expected: func(u *patchTestUser) { u.Name = "Jill" }},
+	{name: "add to array", patch: `[{"op": "add", "path": "/tags/1", "value": "x"}, {"op": "add", "path": "/tags/-", "value": "z"}]`,
+		expected: func(u *patchTestUser) { u.Tags = []string{"a", "x", "b", "z"} }},
+	{name: "remove", patch: `[{"op": "remove", "path": "/tags/0"}, {"op": "remove", "path": "/address/zip"}]`,
+		expected: func(u *patchTestUser) { u.Tags = []string{"b"}; u.Address.Zip = "" }},
+	{name: "move and copy", patch: `[{"op": "copy", "from": "/address/city", "path": "/name"}, {"op": "move", "from": "/tags/0", "path": "/tags/-"}]`,
+		expected: func(u *patchTestUser) { u.Name = "Istanbul"; u.Tags = []string{"b", "a"} }},
+	{name: "test passes", patch: `[{"op": "test", "path": "/age", "value": 9007199254740993}, {"op": "replace", "path": "/age", "value": 1}]`,
+		expected: func(u *patchTestUser) { u.Age = 1 }},
+	{name: "test string", patch: `[{"op": "test", "path": "/address/city", "value": "Istanbul"}]`,
+		expected: func(u *patchTestUser) {}},
+	{name: "test fails", patch: `[{"op": "replace", "path": "/name", "value": "Jill"}, {"op": "test", "path": "/age", "value": 9007199254740992}]`,
+		status: http.StatusConflict, index: 1},
+	{name: "missing path", patch: `[{"op": "remove", "path": "/nickname"}]`, status: http.StatusUnprocessableEntity},
+	{name: "index out of range", patch: `[{"op": "add", "path": "/tags/5", "value": "x"}]`, status: http.StatusUnprocessableEntity},
+	{name: "bad pointer", patch: `[{"op": "add", "path": "name", "value": "x"}]`, status: http.StatusUnprocessableEntity},
+	{name: "unknown op", patch: `[{"op": "frobnicate", "path": "/name"}]`, status: http.StatusUnprocessableEntity},
+}
+
+func TestTools_ReadJSONPatch(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range jsonPatchTests {
+		user := newPatchTestUser()
+		request := httptest.NewRequest("PATCH", "/", strings.NewReader(e.patch))
+		err := testTools.ReadJSONPatch(httptest.NewRecorder(), request, &user)
+
+		if e.status != 0 {
+			var patchError *JSONPatchError
+			if !errors.As(err, &patchError) {
+				t.Errorf("%s: expected *JSONPatchError but got %v", e.name, err)
+				continue
+			}
+			if patchError.StatusCode() != e.status || patchError.Index != e.index {
+				t.Errorf("%s: expected status %d at index %d but got %d at %d", e.name, e.status, e.index, patchError.StatusCode(), patchError.Index)
+			}
+			if !reflect.DeepEqual(user, newPatchTestUser()) {
+				t.Errorf("%s: target modified despite error", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			continue
+		}
+
+		expected := newPatchTestUser()
+		e.expected(&expected)
+		if !reflect.DeepEqual(user, expected) {
+			t.Errorf("%s: expected %+v but got %+v", e.name, expected, user)
+		}
+	}
+}
+
+func TestParseJSONPointer(t *testing.T) {
+	tokens, err := parseJSONPointer("/a~1b/m~0n/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"a/b", "m~n", "0"}) {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+}
@@ -0,0 +1,203 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// ErrorFormat selects the body ErrorJSON writes.
+type ErrorFormat int
+
+const (
+	// ErrorFormatLegacy writes a JSONResponse with error set to true.
+	ErrorFormatLegacy ErrorFormat = iota
+	// ErrorFormatProblem writes an RFC 7807 application/problem+json document.
+	ErrorFormatProblem
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Members in Extensions are
+// written alongside the standard members. A *Problem is also an error, so
+// handlers can pass one straight to ErrorJSON.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+
+	return http.StatusText(p.Status)
+}
+
+func (p *Problem) StatusCode() int {
+	return p.Status
+}
+
+// MarshalJSON writes the standard members and the extensions, leaving out the
+// fields of extension values tagged redact.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		out, err := marshalRedacted(value)
+		if err != nil {
+			return nil, err
+		}
+		members[key] = json.RawMessage(out)
+	}
+
+	members["type"] = p.Type
+	if p.Type == "" {
+		members["type"] = "about:blank"
+	}
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	*p = Problem{}
+	standard := map[string]interface{}{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+	for key, raw := range members {
+		if target, ok := standard[key]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		p.Extensions[key] = value
+	}
+
+	return nil
+}
+
+// ProblemMapping describes the problem type used for a registered error type.
+type ProblemMapping struct {
+	Type   string
+	Title  string
+	Status int
+}
+
+type problemRegistration struct {
+	errorType reflect.Type
+	// sentinel is set instead of errorType for samples of the types
+	// errors.New and fmt.Errorf return.
+	sentinel error
+	mapping  ProblemMapping
+}
+
+// sentinelErrorTypes are the types of errors.New and fmt.Errorf errors. Most
+// plain errors share them, so samples of these types are matched by identity.
+var sentinelErrorTypes = map[reflect.Type]bool{
+	reflect.TypeOf(errors.New("")):                      true,
+	reflect.TypeOf(fmt.Errorf("%w", io.EOF)):            true,
+	reflect.TypeOf(fmt.Errorf("%w %w", io.EOF, io.EOF)): true,
+}
+
+// RegisterProblem maps every error with the same dynamic type as sample (found
+// anywhere in the error chain) to mapping. A sample created by errors.New or
+// fmt.Errorf, such as a sentinel like ErrNotFound, is matched with errors.Is
+// instead, since its type is shared by most plain errors. The status is used
+// by ErrorJSON in both error formats when the caller does not pass one; type
+// and title are only written in ErrorFormatProblem. Register mappings before
+// serving requests. Like http.Handle, it panics if sample is nil, as there is
+// nothing to match.
+func (t *Tools) RegisterProblem(sample error, mapping ProblemMapping) {
+	if sample == nil {
+		panic("toolkit: RegisterProblem called with a nil sample error")
+	}
+
+	registration := problemRegistration{errorType: reflect.TypeOf(sample), mapping: mapping}
+	if sentinelErrorTypes[registration.errorType] {
+		registration.errorType, registration.sentinel = nil, sample
+	}
+	t.problems = append(t.problems, registration)
+}
+
+// problemMappingFor returns the most recently registered mapping matching err.
+func (t *Tools) problemMappingFor(err error) (ProblemMapping, bool) {
+	for i := len(t.problems) - 1; i >= 0; i-- {
+		if sentinel := t.problems[i].sentinel; sentinel != nil {
+			if errors.Is(err, sentinel) {
+				return t.problems[i].mapping, true
+			}
+			continue
+		}
+
+		target := reflect.New(t.problems[i].errorType)
+		if errors.As(err, target.Interface()) {
+			return t.problems[i].mapping, true
+		}
+	}
+
+	return ProblemMapping{}, false
+}
+
+// problemFor builds the problem document ErrorJSON writes for err.
+func (t *Tools) problemFor(err error, status int) Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		p := *problem
+		p.Status = status
+		return p
+	}
+
+	p := Problem{Status: status, Title: http.StatusText(status), Detail: err.Error()}
+	if mapping, ok := t.problemMappingFor(err); ok {
+		p.Type = mapping.Type
+		if mapping.Title != "" {
+			p.Title = mapping.Title
+		}
+	}
+
+	var provider errorDataProvider
+	if errors.As(err, &provider) {
+		p.Extensions = map[string]interface{}{"errors": provider.ErrorData()}
+	}
+
+	return p
+}
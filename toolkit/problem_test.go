@@ -0,0 +1,152 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type problemTestError struct {
+	ID string
+}
+
+func (e *problemTestError) Error() string {
+	return fmt.Sprintf("order %s not found", e.ID)
+}
+
+var problemTests = []struct {
+	name   string
+	err    error
+	status []int
+	code   int
+	ptype  string
+	title  string
+	detail string
+}{
+	{name: "plain error", err: errors.New("some error"), code: http.StatusBadRequest, ptype: "about:blank",
+		title: "Bad Request", detail: "some error"},
+	{name: "explicit status", err: errors.New("some error"), status: []int{http.StatusServiceUnavailable},
+		code: http.StatusServiceUnavailable, ptype: "about:blank", title: "Service Unavailable", detail: "some error"},
+	{name: "registered type", err: fmt.Errorf("loading: %w", &problemTestError{ID: "42"}), code: http.StatusNotFound,
+		ptype: "https://example.com/probs/order-not-found", title: "Order not found", detail: "loading: order 42 not found"},
+	{name: "problem value", err: &Problem{Type: "https://example.com/probs/out-of-credit", Title: "Out of credit",
+		Status: http.StatusForbidden, Detail: "balance is 30"}, code: http.StatusForbidden,
+		ptype: "https://example.com/probs/out-of-credit", title: "Out of credit", detail: "balance is 30"},
+}
+
+func TestTools_ErrorJSONProblem(t *testing.T) {
+	testTools := Tools{ErrorFormat: ErrorFormatProblem}
+	testTools.RegisterProblem(&problemTestError{}, ProblemMapping{
+		Type:   "https://example.com/probs/order-not-found",
+		Title:  "Order not found",
+		Status: http.StatusNotFound,
+	})
+
+	for _, e := range problemTests {
+		responseRecorder := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(responseRecorder, e.err, e.status...); err != nil {
+			t.Error(err)
+		}
+
+		if responseRecorder.Code != e.code {
+			t.Errorf("%s: wrong status code returned; expected %d but got %d", e.name, e.code, responseRecorder.Code)
+		}
+		if ct := responseRecorder.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: wrong content type %s", e.name, ct)
+		}
+
+		var problem Problem
+		if err := json.NewDecoder(responseRecorder.Body).Decode(&problem); err != nil {
+			t.Fatalf("%s: received error when decoding JSON: %s", e.name, err)
+		}
+
+		if problem.Type != e.ptype || problem.Title != e.title || problem.Detail != e.detail || problem.Status != e.code {
+			t.Errorf("%s: unexpected problem %+v", e.name, problem)
+		}
+	}
+}
+
+func TestTools_ErrorJSONProblemExtensions(t *testing.T) {
+	testTools := Tools{ErrorFormat: ErrorFormatProblem}
+	responseRecorder := httptest.NewRecorder()
+
+	_ = testTools.ErrorJSON(responseRecorder, ValidationErrors{"name": {"is required"}})
+
+	if responseRecorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code returned; expected 422 but got %d", responseRecorder.Code)
+	}
+
+	var problem Problem
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&problem); err != nil {
+		t.Fatal("received error when decoding JSON", err)
+	}
+
+	fields, ok := problem.Extensions["errors"].(map[string]interface{})
+	if !ok || fields["name"] == nil {
+		t.Errorf("expected field errors in extensions, got %v", problem.Extensions)
+	}
+}
+
+func TestTools_ErrorJSONLegacyUsesMappedStatus(t *testing.T) {
+	var testTools Tools
+	testTools.RegisterProblem(&problemTestError{}, ProblemMapping{Status: http.StatusNotFound})
+	responseRecorder := httptest.NewRecorder()
+
+	_ = testTools.ErrorJSON(responseRecorder, &problemTestError{ID: "1"})
+
+	if responseRecorder.Code != http.StatusNotFound {
+		t.Errorf("wrong status code returned; expected 404 but got %d", responseRecorder.Code)
+	}
+
+	var payload JSONResponse
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&payload); err != nil || !payload.Error {
+		t.Errorf("expected legacy envelope, got %+v (%v)", payload, err)
+	}
+}
+
+func TestTools_RegisterProblemNil(t *testing.T) {
+	var testTools Tools
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a nil sample to panic")
+		}
+		if len(testTools.problems) != 0 {
+			t.Error("expected the nil sample not to be registered")
+		}
+		_ = testTools.ErrorJSON(httptest.NewRecorder(), errors.New("unmapped"))
+	}()
+
+	testTools.RegisterProblem(nil, ProblemMapping{Status: http.StatusNotFound})
+}
+
+func TestTools_RegisterProblemSentinel(t *testing.T) {
+	errNotFound := errors.New("not found")
+	errGone := fmt.Errorf("gone: %w", errNotFound)
+	var testTools Tools
+	testTools.RegisterProblem(errNotFound, ProblemMapping{Status: http.StatusNotFound})
+	testTools.RegisterProblem(errGone, ProblemMapping{Status: http.StatusGone})
+
+	var tests = []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"sentinel", errNotFound, http.StatusNotFound},
+		{"wrapped sentinel", fmt.Errorf("loading user: %w", errNotFound), http.StatusNotFound},
+		{"wrapping sentinel", fmt.Errorf("loading user: %w", errGone), http.StatusGone},
+		{"other plain error", errors.New("not found"), http.StatusBadRequest},
+		{"other wrapped error", fmt.Errorf("loading user: %w", io.EOF), http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, e.err)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitedError is returned by a RateLimiter in fail mode when a request
+// would have to wait. ErrorJSON answers it with 503 Service Unavailable.
+type RateLimitedError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limit for %s exceeded; retry after %s", e.Key, e.RetryAfter.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("too many requests in flight for %s", e.Key)
+}
+
+func (e *RateLimitedError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// RateLimiter limits the requests JSONClient sends with a token bucket and a
+// cap on requests in flight, both kept separately for each host or, with
+// Key, for any other grouping such as an endpoint. It also slows down when
+// responses carry Retry-After, or X-RateLimit-Remaining of 0 with
+// X-RateLimit-Reset. Configure it through its fields before first use.
+type RateLimiter struct {
+	// Rate is the number of requests per second allowed, with bursts of up
+	// to Burst requests (default: Rate rounded up). A Rate of 0 leaves
+	// requests unlimited apart from MaxInFlight.
+	Rate  float64
+	Burst int
+	// MaxInFlight caps the concurrent requests; 0 means no cap.
+	MaxInFlight int
+	// FailFast returns a *RateLimitedError instead of waiting for capacity.
+	FailFast bool
+	// Key groups requests. It defaults to the request's host.
+	Key func(r *http.Request) string
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	inFlight     chan struct{}
+}
+
+func (l *RateLimiter) key(r *http.Request) string {
+	if l.Key != nil {
+		return l.Key(r)
+	}
+
+	return r.URL.Host
+}
+
+func (l *RateLimiter) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+func (l *RateLimiter) bucket(key string) *rateBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = map[string]*rateBucket{}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: l.burst(), last: time.Now()}
+		if l.MaxInFlight > 0 {
+			b.inFlight = make(chan struct{}, l.MaxInFlight)
+		}
+		l.buckets[key] = b
+	}
+
+	return b
+}
+
+// acquire waits for, or in fail mode checks, capacity for r. The returned
+// function must be called when the request has finished.
+func (l *RateLimiter) acquire(ctx context.Context, r *http.Request) (func(), error) {
+	key := l.key(r)
+	b := l.bucket(key)
+
+	release := func() {}
+	if b.inFlight != nil {
+		if l.FailFast {
+			select {
+			case b.inFlight <- struct{}{}:
+			default:
+				return nil, &RateLimitedError{Key: key}
+			}
+		} else {
+			select {
+			case b.inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		release = func() { <-b.inFlight }
+	}
+
+	for {
+		wait := l.take(b)
+		if wait <= 0 {
+			return release, nil
+		}
+		if l.FailFast {
+			release()
+			return nil, &RateLimitedError{Key: key, RetryAfter: wait}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take removes a token from b, or returns how long to wait for one.
+func (l *RateLimiter) take(b *rateBucket) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+	if l.Rate <= 0 {
+		return 0
+	}
+
+	b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// observe adapts the limiter for r's key to the rate limit headers of
+// response.
+func (l *RateLimiter) observe(r *http.Request, response *http.Response) {
+	b := l.bucket(l.key(r))
+	now := time.Now()
+	var until time.Time
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+			until = now.Add(wait)
+		}
+	}
+
+	remaining, err := strconv.ParseFloat(response.Header.Get("X-RateLimit-Remaining"), 64)
+	if err == nil && remaining <= 0 {
+		if reset, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			// Reset is either a unix time or a number of seconds.
+			resetAt := now.Add(time.Duration(reset) * time.Second)
+			if reset > 1e9 {
+				resetAt = time.Unix(reset, 0)
+			}
+			if resetAt.After(until) {
+				until = resetAt
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+	if err == nil && remaining < b.tokens {
+		b.tokens = math.Max(0, remaining)
+	}
+}
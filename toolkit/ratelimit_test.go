@@ -0,0 +1,105 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONClient_RateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var testTools Tools
+	client := testTools.NewJSONClient(srv.URL)
+	client.RateLimiter = &RateLimiter{Rate: 20, Burst: 1}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := client.Get(context.Background(), "/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("expected requests to be spaced at 20 per second, took %s", elapsed)
+	}
+
+	client.RateLimiter = &RateLimiter{Rate: 1, FailFast: true}
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.Get(context.Background(), "/", nil)
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter <= 0 {
+		t.Fatalf("expected a RateLimitedError with a wait, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected ErrorJSON to answer 503, got %d", rr.Code)
+	}
+}
+
+func TestJSONClient_MaxInFlight(t *testing.T) {
+	var current, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	client := testTools.NewJSONClient(srv.URL)
+	client.RateLimiter = &RateLimiter{MaxInFlight: 2}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Get(context.Background(), "/", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&peak) != 2 {
+		t.Errorf("expected at most 2 requests in flight, saw %d", peak)
+	}
+}
+
+func TestJSONClient_RateLimitHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "2")
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	client := testTools.NewJSONClient(srv.URL)
+	client.RateLimiter = &RateLimiter{FailFast: true}
+
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.Get(context.Background(), "/", nil)
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter < time.Second {
+		t.Errorf("expected the limiter to wait for the reset, got %v", err)
+	}
+}
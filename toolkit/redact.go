@@ -0,0 +1,413 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redactTag marks struct fields that must never be written in a response:
+//
+//	PasswordHash string `json:"password_hash" redact:"true"`
+//
+// WriteJSON, ErrorJSON, the NDJSON writer, the SSE stream, RPC results and
+// Write with the JSON codec all leave such fields out, wherever they appear
+// in the data being written. Other codecs cannot, so Write refuses to send
+// data holding such fields with them.
+const redactTag = "redact"
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	redactableTypes sync.Map
+)
+
+// marshalRedacted marshals data as JSON without the fields tagged redact.
+func marshalRedacted(data interface{}) ([]byte, error) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted, changed, err := redactJSON(out, reflect.ValueOf(data))
+	if err != nil || !changed {
+		return out, err
+	}
+
+	return redacted, nil
+}
+
+// isRedacted reports whether a struct field is tagged for redaction.
+func isRedacted(field reflect.StructField) bool {
+	value, ok := field.Tag.Lookup(redactTag)
+	if !ok {
+		return false
+	}
+
+	redacted, err := strconv.ParseBool(value)
+	return err != nil || redacted
+}
+
+// mayRedact reports whether values of typ can hold a redacted field, so that
+// only those values are walked. Interfaces can hold anything.
+func mayRedact(typ reflect.Type) bool {
+	if cached, ok := redactableTypes.Load(typ); ok {
+		return cached.(bool)
+	}
+
+	result, _ := checkRedactable(typ, map[reflect.Type]bool{})
+	redactableTypes.Store(typ, result)
+	return result
+}
+
+// checkRedactable does the work of mayRedact. visiting holds the types being
+// checked further up, which are assumed not to redact so that recursive
+// types end. A negative answer that relied on that assumption is not final,
+// as complete reports, and is left out of the cache.
+func checkRedactable(typ reflect.Type, visiting map[reflect.Type]bool) (result, complete bool) {
+	if cached, ok := redactableTypes.Load(typ); ok {
+		return cached.(bool), true
+	}
+	if visiting[typ] {
+		return false, false
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+
+	complete = true
+	check := func(t reflect.Type) bool {
+		r, c := checkRedactable(t, visiting)
+		complete = complete && c
+		return r
+	}
+
+	switch {
+	case typ.Implements(jsonMarshalerType) || reflect.PointerTo(typ).Implements(jsonMarshalerType):
+	case typ.Kind() == reflect.Interface:
+		result = true
+	case typ.Kind() == reflect.Ptr, typ.Kind() == reflect.Slice, typ.Kind() == reflect.Array, typ.Kind() == reflect.Map:
+		result = check(typ.Elem())
+	case typ.Kind() == reflect.Struct:
+		for i := 0; i < typ.NumField() && !result; i++ {
+			field := typ.Field(i)
+			result = isRedacted(field) || check(field.Type)
+		}
+	}
+
+	if result || complete {
+		redactableTypes.Store(typ, result)
+	}
+	return result, result || complete
+}
+
+// holdsRedacted reports whether v holds a field tagged redact, for codecs
+// that cannot leave such fields out.
+func holdsRedacted(v reflect.Value, seen map[uintptr]bool) bool {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		if v.Kind() == reflect.Ptr {
+			if seen[v.Pointer()] {
+				return false
+			}
+			seen[v.Pointer()] = true
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || !mayRedact(v.Type()) {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if isRedacted(v.Type().Field(i)) || holdsRedacted(v.Field(i), seen) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if holdsRedacted(iter.Value(), seen) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if holdsRedacted(v.Index(i), seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// redactJSON removes the members of out, the JSON encoding of v, that come
+// from redacted fields. It reports whether anything was removed; when not,
+// the caller keeps out as it was.
+func redactJSON(out []byte, v reflect.Value) ([]byte, bool, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return out, false, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || !mayRedact(v.Type()) {
+		return out, false, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return redactObject(out, func(key string) (reflect.Value, bool) {
+			return structMember(v, key)
+		})
+
+	case reflect.Map:
+		values := make(map[string]reflect.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if key, ok := mapKeyString(iter.Key()); ok {
+				values[key] = iter.Value()
+			}
+		}
+		return redactObject(out, func(key string) (reflect.Value, bool) {
+			return values[key], false
+		})
+
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(out, &items); err != nil || len(items) != v.Len() {
+			return out, false, err
+		}
+
+		changed := false
+		for i := range items {
+			item, itemChanged, err := redactJSON(items[i], v.Index(i))
+			if err != nil {
+				return nil, false, err
+			}
+			items[i], changed = item, changed || itemChanged
+		}
+		if !changed {
+			return out, false, nil
+		}
+
+		return joinJSON('[', ']', items), true, nil
+	}
+
+	return out, false, nil
+}
+
+// redactObject rewrites a JSON object in its original member order. member
+// returns the Go value behind a key, and whether the key must be dropped.
+func redactObject(out []byte, member func(key string) (reflect.Value, bool)) ([]byte, bool, error) {
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return out, false, err
+	}
+
+	var members []json.RawMessage
+	changed := false
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		key, _ := tok.(string)
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, false, err
+		}
+
+		field, drop := member(key)
+		if drop {
+			changed = true
+			continue
+		}
+		if field.IsValid() {
+			redacted, fieldChanged, err := redactJSON(value, field)
+			if err != nil {
+				return nil, false, err
+			}
+			value, changed = redacted, changed || fieldChanged
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		members = append(members, append(append(encodedKey, ':'), value...))
+	}
+	if !changed {
+		return out, false, nil
+	}
+
+	return joinJSON('{', '}', members), true, nil
+}
+
+func joinJSON(open, close byte, items []json.RawMessage) []byte {
+	var b bytes.Buffer
+	b.WriteByte(open)
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(item)
+	}
+	b.WriteByte(close)
+
+	return b.Bytes()
+}
+
+// jsonField is a struct field as encoding/json sees it. A nil index marks a
+// name used by several fields at the same depth, which encoding/json leaves
+// out.
+type jsonField struct {
+	index    []int
+	redacted bool
+}
+
+var jsonFieldCache sync.Map
+
+// jsonFields maps the JSON names of the fields of struct type typ, including
+// those promoted from embedded structs, to the field encoding/json writes
+// under each: the shallowest, or of several at that depth, the only one
+// named by a tag.
+func jsonFields(typ reflect.Type) map[string]jsonField {
+	if cached, ok := jsonFieldCache.Load(typ); ok {
+		return cached.(map[string]jsonField)
+	}
+
+	type candidate struct {
+		name     string
+		index    []int
+		tagged   bool
+		redacted bool
+	}
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	fields := map[string]jsonField{}
+	visited := map[reflect.Type]bool{}
+	for current := []embedded{{typ: typ}}; len(current) > 0; {
+		var next []embedded
+		byName := map[string][]candidate{}
+		var names []string
+
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, _, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), e.index...), i)
+
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				if _, hidden := fields[name]; hidden {
+					continue
+				}
+				if _, ok := byName[name]; !ok {
+					names = append(names, name)
+				}
+				byName[name] = append(byName[name], candidate{name: name, index: index, tagged: tagged, redacted: isRedacted(sf)})
+			}
+		}
+
+		for _, name := range names {
+			candidates := byName[name]
+			if len(candidates) > 1 {
+				var tagged []candidate
+				for _, c := range candidates {
+					if c.tagged {
+						tagged = append(tagged, c)
+					}
+				}
+				candidates = tagged
+			}
+			if len(candidates) != 1 {
+				fields[name] = jsonField{}
+				continue
+			}
+			fields[name] = jsonField{index: candidates[0].index, redacted: candidates[0].redacted}
+		}
+
+		current = next
+	}
+
+	jsonFieldCache.Store(typ, fields)
+	return fields
+}
+
+// structMember finds the field of struct v that encoding/json writes under
+// key, following its rules for fields promoted from embedded structs. The
+// second result reports whether the field is redacted.
+func structMember(v reflect.Value, key string) (reflect.Value, bool) {
+	field, ok := jsonFields(v.Type())[key]
+	if !ok || field.index == nil {
+		return reflect.Value{}, false
+	}
+	if field.redacted {
+		return reflect.Value{}, true
+	}
+
+	value, err := v.FieldByIndexErr(field.index)
+	if err != nil {
+		return reflect.Value{}, false
+	}
+
+	return value, false
+}
+
+// mapKeyString returns the JSON object key encoding/json uses for a map key.
+func mapKeyString(key reflect.Value) (string, bool) {
+	if key.Kind() == reflect.String {
+		return key.String(), true
+	}
+	if key.Type().Implements(textMarshalerType) {
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err == nil
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+
+	return "", false
+}
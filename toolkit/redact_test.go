@@ -0,0 +1,156 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type redactedCredentials struct {
+	Token   string `json:"token" redact:"true"`
+	Scope   string `json:"scope"`
+	Visible string `json:"visible" redact:"false"`
+}
+
+type redactedUser struct {
+	ID           int    `json:"id"`
+	PasswordHash string `json:"password_hash" redact:"true"`
+	Name         string
+	redactedCredentials
+	Sessions map[string]*redactedCredentials `json:"sessions,omitempty"`
+	Friends  []redactedUser                  `json:"friends,omitempty"`
+}
+
+func TestMarshalRedacted(t *testing.T) {
+	user := redactedUser{
+		ID:                  1,
+		PasswordHash:        "hash",
+		Name:                "Ann",
+		redactedCredentials: redactedCredentials{Token: "t1", Scope: "all", Visible: "yes"},
+		Sessions:            map[string]*redactedCredentials{"web": {Token: "t2", Scope: "read"}},
+		Friends:             []redactedUser{{ID: 2, PasswordHash: "hash2"}},
+	}
+
+	out, err := marshalRedacted(map[string]interface{}{"user": &user})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"user":{"id":1,"Name":"Ann","scope":"all","visible":"yes","sessions":{"web":{"scope":"read","visible":""}},"friends":[{"id":2,"Name":"","scope":"","visible":""}]}}`
+	if string(out) != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+
+	plain := struct {
+		B int `json:"b"`
+		A int `json:"a"`
+	}{1, 2}
+	if out, _ := marshalRedacted(plain); string(out) != `{"b":1,"a":2}` {
+		t.Errorf("expected values without redacted fields to be untouched, got %s", out)
+	}
+}
+
+// redactedNode has its recursive field before the redacted one.
+type redactedNode struct {
+	Children []redactedNode `json:"children,omitempty"`
+	Secret   string         `json:"secret" redact:"true"`
+}
+
+type redactedInner struct {
+	Token string `json:"token"`
+}
+
+type redactedOuter struct {
+	redactedInner
+	Token string `json:"token" redact:"true"`
+}
+
+type redactedUntagged struct {
+	Token string
+}
+
+type redactedTagged struct {
+	Token string `json:"Token" redact:"true"`
+}
+
+// redactedSameDepth has two Token fields at the same depth; encoding/json
+// writes the tagged one.
+type redactedSameDepth struct {
+	redactedUntagged
+	redactedTagged
+}
+
+func TestMarshalRedacted_FieldResolution(t *testing.T) {
+	var tests = []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"recursive field first", redactedNode{Secret: "top", Children: []redactedNode{{Secret: "nested"}}}, `{"children":[{}]}`},
+		{"outer field wins over embedded", redactedOuter{redactedInner: redactedInner{Token: "inner"}, Token: "outer"}, `{}`},
+		{"tagged field wins at the same depth", redactedSameDepth{redactedUntagged{Token: "a"}, redactedTagged{Token: "b"}}, `{}`},
+	}
+
+	for _, e := range tests {
+		out, err := marshalRedacted(e.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, out)
+		}
+	}
+}
+
+func TestTools_WriteRedactsWithCodecs(t *testing.T) {
+	var testTools Tools
+	credentials := redactedCredentials{Token: "secret", Scope: "all"}
+
+	for _, accept := range []string{"application/json", "application/xml"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		err := testTools.Write(rr, req, http.StatusOK, &credentials)
+		if strings.Contains(rr.Body.String(), "secret") {
+			t.Errorf("%s: redacted field was written: %s", accept, rr.Body.String())
+		}
+		if (err != nil) != (accept == "application/xml") {
+			t.Errorf("%s: unexpected error %v", accept, err)
+		}
+	}
+}
+
+func TestTools_WriteJSONRedacts(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+
+	err := testTools.WriteJSON(rr, http.StatusOK, JSONResponse{Data: redactedUser{ID: 1, PasswordHash: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(rr.Body.String(), "secret") || strings.Contains(rr.Body.String(), "password_hash") {
+		t.Errorf("redacted field was written: %s", rr.Body.String())
+	}
+}
+
+func TestTools_ErrorJSONProblemRedacts(t *testing.T) {
+	testTools := Tools{ErrorFormat: ErrorFormatProblem}
+	rr := httptest.NewRecorder()
+
+	err := testTools.ErrorJSON(rr, &Problem{
+		Status:     http.StatusUnprocessableEntity,
+		Extensions: map[string]interface{}{"input": redactedUser{ID: 1, PasswordHash: "hunter2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(rr.Body.String(), "hunter2") || strings.Contains(rr.Body.String(), "password_hash") {
+		t.Errorf("redacted field was written: %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"input":{"id":1`) {
+		t.Errorf("expected the extension to be written, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,218 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how JSONClient retries failed requests. Requests
+// are only retried when their method is idempotent or they carry an
+// idempotency key, so that a retry can never apply a change twice.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. It
+	// defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; it defaults to
+	// 100ms and grows by Multiplier (default 2) up to MaxBackoff (default
+	// 10s) with each further retry.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of each wait that is randomised, so that
+	// clients failing together do not retry together. It defaults to 0.5; a
+	// negative value disables it.
+	Jitter float64
+	// RetryStatuses lists the response statuses worth retrying. It defaults
+	// to 429, 502, 503 and 504.
+	RetryStatuses []int
+	// RetryError reports whether a transport error is worth retrying. By
+	// default, connection resets and refusals, unexpected EOFs and network
+	// timeouts are.
+	RetryError func(err error) bool
+	// IdempotencyHeader names the header carrying
+	// RequestOptions.IdempotencyKey. It defaults to Idempotency-Key.
+	IdempotencyHeader string
+	// OnRetry, when set, is called before each retry with the number of the
+	// attempt that failed, its response status or error, and the wait.
+	OnRetry func(attempt int, status int, err error, wait time.Duration)
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil {
+		return 1
+	}
+	if p.MaxAttempts == 0 {
+		return 3
+	}
+
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) idempotencyHeader() string {
+	if p == nil || p.IdempotencyHeader == "" {
+		return "Idempotency-Key"
+	}
+
+	return p.IdempotencyHeader
+}
+
+func (p *RetryPolicy) retryStatus(status int) bool {
+	statuses := p.RetryStatuses
+	if statuses == nil {
+		statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) retryError(err error) bool {
+	if p.RetryError != nil {
+		return p.RetryError(err)
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	}
+
+	return false
+}
+
+// backoff returns the wait before retrying after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, maximum, multiplier, jitter := p.InitialBackoff, p.MaxBackoff, p.Multiplier, p.Jitter
+	if initial == 0 {
+		initial = 100 * time.Millisecond
+	}
+	if maximum == 0 {
+		maximum = 10 * time.Second
+	}
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	if jitter == 0 {
+		jitter = 0.5
+	}
+
+	wait := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if wait > float64(maximum) {
+		wait = float64(maximum)
+	}
+	if jitter > 0 {
+		wait -= wait * math.Min(jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(wait)
+}
+
+// idempotentMethods are the methods RFC 9110 defines as idempotent.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// sendWithRetries sends the requests built by newRequest until one succeeds,
+// fails in a way that is not worth retrying, or the retry policy runs out.
+func (c *JSONClient) sendWithRetries(ctx context.Context, newRequest func() (*http.Request, error), options RequestOptions) (*http.Response, []byte, error) {
+	policy := c.Retry
+	for attempt := 1; ; attempt++ {
+		*options.Attempts = attempt
+
+		request, err := newRequest()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		response, data, err := c.attempt(request)
+		var open *CircuitOpenError
+		var limited *RateLimitedError
+		if errors.As(err, &open) || errors.As(err, &limited) {
+			return response, data, err
+		}
+		if policy == nil || attempt >= policy.maxAttempts() || ctx.Err() != nil {
+			return response, data, err
+		}
+		if !idempotentMethods[request.Method] && request.Header.Get(policy.idempotencyHeader()) == "" {
+			return response, data, err
+		}
+
+		status := 0
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if !policy.retryError(err) {
+				return response, data, err
+			}
+			wait = policy.backoff(attempt)
+		case policy.retryStatus(response.StatusCode):
+			status = response.StatusCode
+			var ok bool
+			if wait, ok = retryAfter(response.Header.Get("Retry-After")); !ok {
+				wait = policy.backoff(attempt)
+			}
+		default:
+			return response, data, nil
+		}
+
+		// Give up early when the wait would outlast the caller's deadline.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return response, data, err
+		}
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, status, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return response, data, err
+		case <-timer.C:
+		}
+	}
+}
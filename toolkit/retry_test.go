@@ -0,0 +1,134 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer fails the first failures requests with status, or by
+// dropping the connection when status is 0, and records the bodies received.
+func newFlakyServer(failures int32, status int, header http.Header) (*httptest.Server, *int32, *[]string) {
+	var calls int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		if atomic.AddInt32(&calls, 1) <= failures {
+			if status == 0 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+				return
+			}
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+
+	return srv, &calls, &bodies
+}
+
+func TestJSONClient_Retry(t *testing.T) {
+	fastPolicy := func() *RetryPolicy {
+		return &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	}
+
+	var retryTests = []struct {
+		name     string
+		status   int
+		failures int32
+		method   string
+		key      string
+		calls    int32
+		success  bool
+	}{
+		{name: "recovers from 503", status: http.StatusServiceUnavailable, failures: 2, method: http.MethodGet, calls: 3, success: true},
+		{name: "gives up after max attempts", status: http.StatusBadGateway, failures: 5, method: http.MethodGet, calls: 3},
+		{name: "does not retry 500", status: http.StatusInternalServerError, failures: 1, method: http.MethodGet, calls: 1},
+		{name: "connection reset", status: 0, failures: 1, method: http.MethodPut, calls: 2, success: true},
+		{name: "post not retried", status: http.StatusServiceUnavailable, failures: 1, method: http.MethodPost, calls: 1},
+		{name: "post with idempotency key", status: http.StatusServiceUnavailable, failures: 1, method: http.MethodPost, key: "abc", calls: 2, success: true},
+	}
+
+	for _, e := range retryTests {
+		srv, calls, bodies := newFlakyServer(e.failures, e.status, nil)
+
+		var testTools Tools
+		client := testTools.NewJSONClient(srv.URL)
+		client.Retry = fastPolicy()
+
+		attempts := 0
+		_, err := client.Do(context.Background(), e.method, "/", map[string]int{"n": 1}, nil, RequestOptions{IdempotencyKey: e.key, Attempts: &attempts})
+		srv.Close()
+
+		if e.success != (err == nil) {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if *calls != e.calls || int32(attempts) != e.calls {
+			t.Errorf("%s: expected %d calls, got %d (reported %d)", e.name, e.calls, *calls, attempts)
+		}
+		for _, body := range *bodies {
+			if body != `{"n":1}` {
+				t.Errorf("%s: expected the body to be replayed, got %q", e.name, body)
+			}
+		}
+
+		var remoteErr *RemoteError
+		if errors.As(err, &remoteErr) && remoteErr.Attempts != int(e.calls) {
+			t.Errorf("%s: expected RemoteError to report %d attempts, got %d", e.name, e.calls, remoteErr.Attempts)
+		}
+	}
+}
+
+func TestJSONClient_RetryAfter(t *testing.T) {
+	srv, calls, _ := newFlakyServer(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	defer srv.Close()
+
+	var waits []time.Duration
+	var testTools Tools
+	client := testTools.NewJSONClient(srv.URL)
+	client.Retry = &RetryPolicy{OnRetry: func(attempt, status int, err error, wait time.Duration) {
+		waits = append(waits, wait)
+	}}
+
+	start := time.Now()
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if *calls != 2 || len(waits) != 1 || waits[0] != time.Second || time.Since(start) < time.Second {
+		t.Errorf("expected one retry after the Retry-After wait, got %d calls and waits %v", *calls, waits)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: -1}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, want, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(2); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("jittered backoff %s outside [100ms, 200ms]", got)
+		}
+	}
+
+	if wait, ok := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || wait < 59*time.Minute {
+		t.Errorf("expected an HTTP date Retry-After to be parsed, got %s", wait)
+	}
+}
@@ -0,0 +1,428 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+)
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// RPCError is a JSON-RPC 2.0 error object. Methods may return one to choose
+// the code sent to the caller; any other error is reported as an internal
+// error. RPCClient returns it when the server answers with an error.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RPCServer is an http.Handler serving JSON-RPC 2.0 requests, including
+// batches and notifications, over HTTP POST.
+type RPCServer struct {
+	// ErrorLog records methods that panic. It defaults to the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	tools   *Tools
+	methods map[string]reflect.Value
+}
+
+// NewRPCServer returns a JSON-RPC server that reads requests with the limits
+// of t and decodes params with its strict decoding and validation settings.
+func (t *Tools) NewRPCServer() *RPCServer {
+	return &RPCServer{tools: t, methods: map[string]reflect.Value{}}
+}
+
+// Register makes fn callable as method. fn must have one of the signatures
+//
+//	func(ctx context.Context) (Result, error)
+//	func(ctx context.Context, params Params) (Result, error)
+//
+// where Params is any type the request's params decode into. Register
+// methods before serving requests.
+func (s *RPCServer) Register(method string, fn interface{}) error {
+	if method == "" || strings.HasPrefix(method, "rpc.") {
+		return fmt.Errorf("method name %q is reserved", method)
+	}
+
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return fmt.Errorf("method %q must be a function, not %T", method, fn)
+	}
+
+	typ := value.Type()
+	if typ.NumIn() < 1 || typ.NumIn() > 2 || typ.In(0) != contextType ||
+		typ.NumOut() != 2 || typ.Out(1) != errorType {
+		return fmt.Errorf("method %q must be a func(context.Context[, params]) (result, error), not %s", method, typ)
+	}
+
+	s.methods[method] = value
+	return nil
+}
+
+// ServeHTTP answers a single JSON-RPC request or a batch. Requests made up
+// only of notifications get 204 No Content.
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		_ = s.tools.ErrorJSON(w, errors.New("json-rpc requests must use POST"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := s.tools.readBody(w, r)
+	if err != nil {
+		code := RPCParseError
+		var tooLarge *JSONTooLargeError
+		if errors.As(err, &tooLarge) {
+			code = RPCInvalidRequest
+		}
+		s.write(w, rpcErrorResponse(nil, &RPCError{Code: code, Message: err.Error()}))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		s.write(w, rpcErrorResponse(nil, &RPCError{Code: RPCParseError, Message: "parse error"}))
+		return
+	}
+
+	if body[0] != '[' {
+		if response := s.handle(r.Context(), body); response != nil {
+			s.write(w, response)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var batch []json.RawMessage
+	_ = json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		s.write(w, rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "batch must not be empty"}))
+		return
+	}
+
+	responses := []*rpcResponse{}
+	for _, raw := range batch {
+		if response := s.handle(r.Context(), raw); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.write(w, responses)
+}
+
+func (s *RPCServer) write(w http.ResponseWriter, data interface{}) {
+	_ = s.tools.WriteJSON(w, http.StatusOK, data)
+}
+
+func rpcErrorResponse(id json.RawMessage, err *RPCError) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+// handle runs one request and returns its response, or nil for a
+// notification.
+func (s *RPCServer) handle(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var request rpcRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "request must be an object"})
+	}
+
+	id := request.ID
+	if id != nil && !rpcValidID(id) {
+		return rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "id must be a string, a number or null"})
+	}
+	if request.JSONRPC != "2.0" {
+		return rpcErrorResponse(id, &RPCError{Code: RPCInvalidRequest, Message: `jsonrpc must be "2.0"`})
+	}
+	if request.Method == "" {
+		return rpcErrorResponse(id, &RPCError{Code: RPCInvalidRequest, Message: "method is required"})
+	}
+
+	result, rpcErr := s.call(ctx, request.Method, request.Params)
+	if id == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return rpcErrorResponse(id, rpcErr)
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: id}
+}
+
+func rpcValidID(id json.RawMessage) bool {
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+
+	return false
+}
+
+// call decodes params for method, invokes it and marshals its result.
+func (s *RPCServer) call(ctx context.Context, method string, params json.RawMessage) (result json.RawMessage, rpcErr *RPCError) {
+	fn, ok := s.methods[method]
+	if !ok {
+		return nil, &RPCError{Code: RPCMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+	}
+
+	hasParams := len(params) > 0 && string(params) != "null"
+	if hasParams && params[0] != '{' && params[0] != '[' {
+		return nil, &RPCError{Code: RPCInvalidParams, Message: "params must be an object or an array"}
+	}
+
+	args := []reflect.Value{reflect.ValueOf(ctx)}
+	if fn.Type().NumIn() == 2 {
+		target := reflect.New(fn.Type().In(1))
+		if hasParams {
+			// The tools' schema describes whole request bodies, not params.
+			if err := s.tools.decodeJSON(params, target.Interface(), nil); err != nil {
+				invalid := &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+				var provider errorDataProvider
+				if errors.As(err, &provider) {
+					invalid.Data = provider.ErrorData()
+				}
+				return nil, invalid
+			}
+		}
+		args = append(args, target.Elem())
+	} else if hasParams && string(params) != "{}" && string(params) != "[]" {
+		return nil, &RPCError{Code: RPCInvalidParams, Message: fmt.Sprintf("method %q takes no params", method)}
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.logf("rpc: method %q panicked: %v\n%s", method, recovered, debug.Stack())
+			result, rpcErr = nil, &RPCError{Code: RPCInternalError, Message: "internal error"}
+		}
+	}()
+
+	out := fn.Call(args)
+	if err, _ := out[1].Interface().(error); err != nil {
+		var methodErr *RPCError
+		if errors.As(err, &methodErr) {
+			return nil, methodErr
+		}
+
+		internal := &RPCError{Code: RPCInternalError, Message: err.Error()}
+		var provider errorDataProvider
+		if errors.As(err, &provider) {
+			internal.Data = provider.ErrorData()
+		}
+		return nil, internal
+	}
+
+	encoded, err := marshalRedacted(out[0].Interface())
+	if err != nil {
+		return nil, &RPCError{Code: RPCInternalError, Message: err.Error()}
+	}
+
+	return encoded, nil
+}
+
+func (s *RPCServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// RPCClient calls methods on a JSON-RPC 2.0 server.
+type RPCClient struct {
+	uri    string
+	client *JSONClient
+	nextID int64
+}
+
+// NewRPCClient returns a client for the JSON-RPC endpoint at uri, optionally
+// using a custom http.Client as PushJSONToRemote does.
+func (t *Tools) NewRPCClient(uri string, client ...*http.Client) *RPCClient {
+	remote := t.NewJSONClient("")
+	if len(client) > 0 {
+		remote.HTTPClient = client[0]
+	}
+
+	return t.NewRPCClientFor(remote, uri)
+}
+
+// NewRPCClientFor returns a client for the JSON-RPC endpoint at uri that
+// sends its requests through client, sharing its headers, timeout and other
+// settings. uri may be relative to the client's BaseURL.
+func (t *Tools) NewRPCClientFor(client *JSONClient, uri string) *RPCClient {
+	return &RPCClient{uri: uri, client: client}
+}
+
+// RPCCall is one call of a batch sent with RPCClient.Batch. After the batch
+// returns, Error holds the call's own error, if any, and Result the decoded
+// result.
+type RPCCall struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Error  error
+}
+
+// Call invokes method with params and decodes its result into result, which
+// may be nil. A JSON-RPC error from the server is returned as *RPCError.
+func (c *RPCClient) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	call := RPCCall{Method: method, Params: params, Result: result}
+	if err := c.Batch(ctx, []*RPCCall{&call}); err != nil {
+		return err
+	}
+
+	return call.Error
+}
+
+// Notify sends method as a notification, for which the server sends no
+// result.
+func (c *RPCClient) Notify(ctx context.Context, method string, params interface{}) error {
+	request, err := c.request(method, params, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.send(ctx, request)
+	return err
+}
+
+// Batch sends calls as a single batch request. The returned error covers the
+// exchange as a whole; errors of individual calls are stored in their Error
+// fields.
+func (c *RPCClient) Batch(ctx context.Context, calls []*RPCCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	requests := make([]rpcRequest, 0, len(calls))
+	byID := make(map[string]*RPCCall, len(calls))
+	for _, call := range calls {
+		id := json.RawMessage(fmt.Sprint(atomic.AddInt64(&c.nextID, 1)))
+		request, err := c.request(call.Method, call.Params, id)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, request)
+		byID[string(id)] = call
+	}
+
+	var payload interface{} = requests
+	if len(requests) == 1 {
+		payload = requests[0]
+	}
+
+	body, err := c.send(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	var responses []rpcResponse
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		var response rpcResponse
+		err = json.Unmarshal(body, &response)
+		responses = append(responses, response)
+	} else {
+		err = json.Unmarshal(body, &responses)
+	}
+	if err != nil {
+		return fmt.Errorf("json-rpc response is not valid: %w", err)
+	}
+
+	for _, response := range responses {
+		call, ok := byID[string(response.ID)]
+		if !ok {
+			if response.Error != nil {
+				return response.Error
+			}
+			continue
+		}
+		delete(byID, string(response.ID))
+
+		switch {
+		case response.Error != nil:
+			call.Error = response.Error
+		case call.Result != nil:
+			call.Error = json.Unmarshal(response.Result, call.Result)
+		}
+	}
+	for id, call := range byID {
+		call.Error = fmt.Errorf("json-rpc response for request %s is missing", id)
+	}
+
+	return nil
+}
+
+func (c *RPCClient) request(method string, params interface{}, id json.RawMessage) (rpcRequest, error) {
+	request := rpcRequest{JSONRPC: "2.0", Method: method, ID: id}
+	if params != nil {
+		encoded, err := json.Marshal(params)
+		if err != nil {
+			return request, err
+		}
+		request.Params = encoded
+	}
+
+	return request, nil
+}
+
+// send posts payload and returns the response body.
+func (c *RPCClient) send(ctx context.Context, payload interface{}) ([]byte, error) {
+	response, body, err := c.client.send(ctx, http.MethodPost, c.uri, payload, RequestOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("json-rpc server responded with status %d", response.StatusCode)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,156 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type rpcAddParams struct {
+	A int `json:"a"`
+	B int `json:"b" validate:"max=100"`
+}
+
+func newTestRPCServer(t *testing.T) *RPCServer {
+	testTools := Tools{ValidateJSON: true}
+	server := testTools.NewRPCServer()
+	server.ErrorLog = log.New(io.Discard, "", 0)
+
+	methods := map[string]interface{}{
+		"add": func(ctx context.Context, p rpcAddParams) (int, error) { return p.A + p.B, nil },
+		"sum": func(ctx context.Context, values []int) (int, error) {
+			total := 0
+			for _, v := range values {
+				total += v
+			}
+			return total, nil
+		},
+		"ping":  func(ctx context.Context) (string, error) { return "pong", nil },
+		"fail":  func(ctx context.Context) (interface{}, error) { return nil, &RPCError{Code: 42, Message: "custom"} },
+		"crash": func(ctx context.Context) (interface{}, error) { return nil, errors.New("boom") },
+		"wrapped": func(ctx context.Context) (interface{}, error) {
+			return nil, fmt.Errorf("saving: %w", ValidationErrors{"name": {"is required"}})
+		},
+		"panic": func(ctx context.Context) (int, error) { panic("secret state") },
+		"secret": func(ctx context.Context) (redactedCredentials, error) {
+			return redactedCredentials{Token: "t", Scope: "s"}, nil
+		},
+	}
+	for name, fn := range methods {
+		if err := server.Register(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return server
+}
+
+func TestRPCServer_Register(t *testing.T) {
+	var testTools Tools
+	server := testTools.NewRPCServer()
+
+	if err := server.Register("rpc.internal", func(ctx context.Context) (int, error) { return 0, nil }); err == nil {
+		t.Error("expected an error for a reserved method name")
+	}
+	if err := server.Register("bad", func(a, b int) int { return 0 }); err == nil {
+		t.Error("expected an error for a function without a context and error")
+	}
+	if err := server.Register("nil", nil); err == nil {
+		t.Error("expected an error for a nil function")
+	}
+}
+
+func TestRPCServer_ServeHTTP(t *testing.T) {
+	server := newTestRPCServer(t)
+
+	var rpcTests = []struct {
+		name     string
+		method   string
+		body     string
+		status   int
+		expected string
+	}{
+		{name: "named params", method: "POST", body: `{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":1}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{name: "positional params", method: "POST", body: `{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":"x"}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","result":6,"id":"x"}`},
+		{name: "no params", method: "POST", body: `{"jsonrpc":"2.0","method":"ping","id":null}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","result":"pong","id":null}`},
+		{name: "notification", method: "POST", body: `{"jsonrpc":"2.0","method":"ping"}`, status: http.StatusNoContent, expected: ``},
+		{name: "parse error", method: "POST", body: `{"jsonrpc":`, status: http.StatusOK, expected: `"code":-32700`},
+		{name: "wrong version", method: "POST", body: `{"jsonrpc":"1.0","method":"ping","id":1}`, status: http.StatusOK, expected: `"code":-32600`},
+		{name: "invalid id", method: "POST", body: `{"jsonrpc":"2.0","method":"ping","id":{}}`, status: http.StatusOK, expected: `"code":-32600`},
+		{name: "empty batch", method: "POST", body: `[]`, status: http.StatusOK, expected: `"code":-32600`},
+		{name: "unknown method", method: "POST", body: `{"jsonrpc":"2.0","method":"nope","id":1}`, status: http.StatusOK, expected: `"code":-32601`},
+		{name: "unknown param", method: "POST", body: `{"jsonrpc":"2.0","method":"add","params":{"c":1},"id":1}`, status: http.StatusOK, expected: `"code":-32602`},
+		{name: "invalid param", method: "POST", body: `{"jsonrpc":"2.0","method":"add","params":{"b":101},"id":1}`, status: http.StatusOK, expected: `"data":{"b":`},
+		{name: "unexpected params", method: "POST", body: `{"jsonrpc":"2.0","method":"ping","params":[1],"id":1}`, status: http.StatusOK, expected: `"code":-32602`},
+		{name: "custom error", method: "POST", body: `{"jsonrpc":"2.0","method":"fail","id":1}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","error":{"code":42,"message":"custom"},"id":1}`},
+		{name: "internal error", method: "POST", body: `{"jsonrpc":"2.0","method":"crash","id":1}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","error":{"code":-32603,"message":"boom"},"id":1}`},
+		{name: "wrapped error data", method: "POST", body: `{"jsonrpc":"2.0","method":"wrapped","id":1}`, status: http.StatusOK, expected: `"data":{"name":["is required"]}`},
+		{name: "panic", method: "POST", body: `{"jsonrpc":"2.0","method":"panic","id":1}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"},"id":1}`},
+		{name: "redacted result", method: "POST", body: `{"jsonrpc":"2.0","method":"secret","id":1}`, status: http.StatusOK, expected: `{"jsonrpc":"2.0","result":{"scope":"s","visible":""},"id":1}`},
+		{name: "batch", method: "POST", body: `[{"jsonrpc":"2.0","method":"ping","id":1},{"jsonrpc":"2.0","method":"ping"},1]`, status: http.StatusOK, expected: `[{"jsonrpc":"2.0","result":"pong","id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"request must be an object"},"id":null}]`},
+		{name: "batch of notifications", method: "POST", body: `[{"jsonrpc":"2.0","method":"ping"}]`, status: http.StatusNoContent, expected: ``},
+		{name: "wrong method", method: "GET", body: ``, status: http.StatusMethodNotAllowed, expected: `POST`},
+	}
+
+	for _, e := range rpcTests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(e.method, "/rpc", strings.NewReader(e.body))
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expected) {
+			t.Errorf("%s: expected body containing %s, got %s", e.name, e.expected, rr.Body.String())
+		}
+	}
+}
+
+func TestRPCClient(t *testing.T) {
+	srv := httptest.NewServer(newTestRPCServer(t))
+	defer srv.Close()
+
+	var testTools Tools
+	client := testTools.NewRPCClient(srv.URL, srv.Client())
+	ctx := context.Background()
+
+	var sum int
+	if err := client.Call(ctx, "add", rpcAddParams{A: 2, B: 5}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 7 {
+		t.Errorf("expected 7, got %d", sum)
+	}
+
+	err := client.Call(ctx, "fail", nil, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 42 {
+		t.Errorf("expected RPC error 42, got %v", err)
+	}
+
+	if err := client.Notify(ctx, "ping", nil); err != nil {
+		t.Errorf("notify failed: %v", err)
+	}
+
+	var pong string
+	calls := []*RPCCall{
+		{Method: "ping", Result: &pong},
+		{Method: "sum", Params: []int{1, 2}, Result: &sum},
+		{Method: "nope"},
+	}
+	if err := client.Batch(ctx, calls); err != nil {
+		t.Fatal(err)
+	}
+	if pong != "pong" || sum != 3 || calls[0].Error != nil || calls[1].Error != nil {
+		t.Errorf("unexpected batch results: %q %d %v %v", pong, sum, calls[0].Error, calls[1].Error)
+	}
+	if !errors.As(calls[2].Error, &rpcErr) || rpcErr.Code != RPCMethodNotFound {
+		t.Errorf("expected method not found, got %v", calls[2].Error)
+	}
+}
@@ -0,0 +1,449 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaViolation describes one way a JSON document failed a schema.
+// InstancePath is a JSON Pointer to the offending value.
+type SchemaViolation struct {
+	InstancePath string `json:"instancePath"`
+	Keyword      string `json:"keyword"`
+	Message      string `json:"message"`
+}
+
+// SchemaErrors lists every violation found when validating against a schema.
+type SchemaErrors []SchemaViolation
+
+func (s SchemaErrors) Error() string {
+	messages := make([]string, 0, len(s))
+	for _, v := range s {
+		path := v.InstancePath
+		if path == "" {
+			path = "/"
+		}
+		messages = append(messages, fmt.Sprintf("%s %s", path, v.Message))
+	}
+
+	return "body does not match schema: " + strings.Join(messages, "; ")
+}
+
+func (s SchemaErrors) ErrorData() interface{} {
+	return []SchemaViolation(s)
+}
+
+func (s SchemaErrors) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// Schema is a compiled JSON Schema. It supports the draft 2020-12 core
+// keywords type, properties, required, enum, const, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, minItems,
+// maxItems, items, additionalProperties and local $ref references into
+// $defs or any other part of the same document.
+type Schema struct {
+	root *schemaNode
+}
+
+type schemaNode struct {
+	always *bool
+
+	types                []string
+	properties           map[string]*schemaNode
+	required             []string
+	enum                 []interface{}
+	constValue           *interface{}
+	pattern              *regexp.Regexp
+	minimum              *big.Rat
+	maximum              *big.Rat
+	exclusiveMinimum     *big.Rat
+	exclusiveMaximum     *big.Rat
+	minLength            *int
+	maxLength            *int
+	minItems             *int
+	maxItems             *int
+	items                *schemaNode
+	additionalProperties *schemaNode
+	ref                  *schemaNode
+}
+
+// LoadSchema compiles a JSON Schema document.
+func LoadSchema(data []byte) (*Schema, error) {
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+
+	compiler := schemaCompiler{document: document, refs: map[string]*schemaNode{}}
+	root, err := compiler.compile(document, "#")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{root: root}, nil
+}
+
+// LoadSchemaFile compiles the JSON Schema stored in the file at path.
+func LoadSchemaFile(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadSchema(data)
+}
+
+// Validate checks a JSON document against the schema and returns SchemaErrors
+// listing every violation, or nil.
+func (s *Schema) Validate(data []byte) error {
+	var instance interface{}
+	if err := decodeSingleJSON(data, &instance); err != nil {
+		return err
+	}
+
+	return s.validateInstance(instance)
+}
+
+func (s *Schema) validateInstance(instance interface{}) error {
+	var violations SchemaErrors
+	s.root.validate(instance, "", &violations)
+	if len(violations) > 0 {
+		return violations
+	}
+
+	return nil
+}
+
+type schemaCompiler struct {
+	document interface{}
+	refs     map[string]*schemaNode
+}
+
+func (c *schemaCompiler) compile(raw interface{}, location string) (*schemaNode, error) {
+	if b, ok := raw.(bool); ok {
+		return &schemaNode{always: &b}, nil
+	}
+
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema at %s must be an object or a boolean", location)
+	}
+
+	node := &schemaNode{}
+	for keyword, value := range object {
+		var err error
+		at := location + "/" + keyword
+		switch keyword {
+		case "type":
+			if node.types, err = schemaStrings(value, at); err == nil {
+				err = schemaTypeNames(node.types, at)
+			}
+		case "required":
+			node.required, err = schemaStrings(value, at)
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok {
+				err = fmt.Errorf("schema keyword %s must be an array", at)
+			}
+			node.enum = list
+		case "const":
+			constValue := value
+			node.constValue = &constValue
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("schema keyword %s must be a string", at)
+				break
+			}
+			node.pattern, err = regexp.Compile(pattern)
+		case "minimum":
+			node.minimum, err = schemaNumber(value, at)
+		case "maximum":
+			node.maximum, err = schemaNumber(value, at)
+		case "exclusiveMinimum":
+			node.exclusiveMinimum, err = schemaNumber(value, at)
+		case "exclusiveMaximum":
+			node.exclusiveMaximum, err = schemaNumber(value, at)
+		case "minLength":
+			node.minLength, err = schemaCount(value, at)
+		case "maxLength":
+			node.maxLength, err = schemaCount(value, at)
+		case "minItems":
+			node.minItems, err = schemaCount(value, at)
+		case "maxItems":
+			node.maxItems, err = schemaCount(value, at)
+		case "items":
+			node.items, err = c.compile(value, at)
+		case "additionalProperties":
+			node.additionalProperties, err = c.compile(value, at)
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("schema keyword %s must be an object", at)
+				break
+			}
+			node.properties = make(map[string]*schemaNode, len(properties))
+			for name, property := range properties {
+				if node.properties[name], err = c.compile(property, at+"/"+name); err != nil {
+					break
+				}
+			}
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("schema keyword %s must be a string", at)
+				break
+			}
+			node.ref, err = c.resolve(ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// resolve compiles the schema a local $ref points at. Nodes are cached before
+// they are compiled so that recursive references terminate.
+func (c *schemaCompiler) resolve(ref string) (*schemaNode, error) {
+	if node, ok := c.refs[ref]; ok {
+		return node, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("schema reference %q is not supported; only local references are", ref)
+	}
+
+	target, err := getPointer(c.document, strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("schema reference %q cannot be resolved: %w", ref, err)
+	}
+
+	node := &schemaNode{}
+	c.refs[ref] = node
+	compiled, err := c.compile(target, ref)
+	if err != nil {
+		return nil, err
+	}
+	*node = *compiled
+
+	return node, nil
+}
+
+func schemaStrings(value interface{}, at string) ([]string, error) {
+	if s, ok := value.(string); ok {
+		return []string{s}, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema keyword %s must be a string or an array of strings", at)
+	}
+
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema keyword %s must only contain strings", at)
+		}
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// schemaTypeNames checks that types only holds JSON Schema type names.
+func schemaTypeNames(types []string, at string) error {
+	for _, name := range types {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("schema keyword %s names unknown type %q", at, name)
+		}
+	}
+
+	return nil
+}
+
+func schemaNumber(value interface{}, at string) (*big.Rat, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("schema keyword %s must be a number", at)
+	}
+
+	rat, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return nil, fmt.Errorf("schema keyword %s must be a number", at)
+	}
+
+	return rat, nil
+}
+
+func schemaCount(value interface{}, at string) (*int, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("schema keyword %s must be a non-negative integer", at)
+	}
+
+	count, err := strconv.Atoi(number.String())
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("schema keyword %s must be a non-negative integer", at)
+	}
+
+	return &count, nil
+}
+
+// jsonTypeOf returns the JSON Schema type name of a generic JSON value.
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if rat, ok := new(big.Rat).SetString(v.String()); ok && rat.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+func (n *schemaNode) validate(instance interface{}, path string, violations *SchemaErrors) {
+	add := func(keyword, format string, args ...interface{}) {
+		*violations = append(*violations, SchemaViolation{InstancePath: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n.always != nil {
+		if !*n.always {
+			add("false", "is not allowed")
+		}
+		return
+	}
+
+	if n.ref != nil {
+		n.ref.validate(instance, path, violations)
+	}
+
+	instanceType := jsonTypeOf(instance)
+	if len(n.types) > 0 {
+		matched := false
+		for _, t := range n.types {
+			if t == instanceType || (t == "number" && instanceType == "integer") {
+				matched = true
+			}
+		}
+		if !matched {
+			add("type", "must be of type %s, not %s", strings.Join(n.types, " or "), instanceType)
+			return
+		}
+	}
+
+	if n.enum != nil {
+		found := false
+		for _, option := range n.enum {
+			if jsonEqual(instance, option) {
+				found = true
+			}
+		}
+		if !found {
+			add("enum", "must be one of the allowed values")
+		}
+	}
+
+	if n.constValue != nil && !jsonEqual(instance, *n.constValue) {
+		add("const", "must be equal to the constant value")
+	}
+
+	switch value := instance.(type) {
+	case json.Number:
+		rat, ok := new(big.Rat).SetString(value.String())
+		if !ok {
+			return
+		}
+		if n.minimum != nil && rat.Cmp(n.minimum) < 0 {
+			add("minimum", "must be >= %s", n.minimum.RatString())
+		}
+		if n.maximum != nil && rat.Cmp(n.maximum) > 0 {
+			add("maximum", "must be <= %s", n.maximum.RatString())
+		}
+		if n.exclusiveMinimum != nil && rat.Cmp(n.exclusiveMinimum) <= 0 {
+			add("exclusiveMinimum", "must be > %s", n.exclusiveMinimum.RatString())
+		}
+		if n.exclusiveMaximum != nil && rat.Cmp(n.exclusiveMaximum) >= 0 {
+			add("exclusiveMaximum", "must be < %s", n.exclusiveMaximum.RatString())
+		}
+
+	case string:
+		length := utf8.RuneCountInString(value)
+		if n.minLength != nil && length < *n.minLength {
+			add("minLength", "must be at least %d characters", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			add("maxLength", "must be at most %d characters", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(value) {
+			add("pattern", "must match %s", n.pattern.String())
+		}
+
+	case []interface{}:
+		if n.minItems != nil && len(value) < *n.minItems {
+			add("minItems", "must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(value) > *n.maxItems {
+			add("maxItems", "must have at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, item := range value {
+				n.items.validate(item, path+"/"+strconv.Itoa(i), violations)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range n.required {
+			if _, ok := value[name]; !ok {
+				add("required", "must have required property %q", name)
+			}
+		}
+
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			childPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+			if property, ok := n.properties[name]; ok {
+				property.validate(value[name], childPath, violations)
+			} else if extra := n.additionalProperties; extra != nil && extra.always != nil && !*extra.always {
+				*violations = append(*violations, SchemaViolation{
+					InstancePath: childPath,
+					Keyword:      "additionalProperties",
+					Message:      "is not an allowed property",
+				})
+			} else if extra != nil {
+				extra.validate(value[name], childPath, violations)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var schemaTests = []struct {
+	name       string
+	json       string
+	violations []string
+}{
+	{name: "valid", json: `{"id": "ORD-1", "status": "new", "customer": {"email": "a@b.c"},
+		"lines": [{"sku": "ABC", "quantity": 2}], "notes": null}`},
+	{name: "missing required", json: `{"id": "ORD-1"}`, violations: []string{":required", ":required"}},
+	{name: "types and ranges", json: `{"id": "X-1", "status": "lost", "customer": {"email": "nobody"},
+		"lines": [{"sku": "AB", "quantity": 1.5}, {"sku": "ABCDEFGHI", "quantity": 100}], "notes": 1}`,
+		violations: []string{
+			"/customer/email:pattern", "/id:pattern", "/lines/0/quantity:type", "/lines/0/sku:minLength",
+			"/lines/1/quantity:exclusiveMaximum", "/lines/1/sku:maxLength", "/notes:type", "/status:enum",
+		}},
+	{name: "additional property", json: `{"id": "ORD-1", "customer": {"email": "a@b.c"}, "lines": [{"sku": "ABC", "quantity": 1}],
+		"extra": true}`, violations: []string{"/extra:additionalProperties"}},
+	{name: "recursive ref", json: `{"id": "ORD-1", "customer": {"email": "a@b.c", "referrer": {"name": "x"}},
+		"lines": [{"sku": "ABC", "quantity": 1}]}`, violations: []string{"/customer/referrer:required"}},
+	{name: "array limits", json: `{"id": "ORD-1", "customer": {"email": "a@b.c"}, "lines": []}`,
+		violations: []string{"/lines:minItems"}},
+}
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := LoadSchemaFile("./test/schemas/order.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range schemaTests {
+		err := schema.Validate([]byte(e.json))
+		if len(e.violations) == 0 {
+			if err != nil {
+				t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			}
+			continue
+		}
+
+		var schemaErrors SchemaErrors
+		if !errors.As(err, &schemaErrors) {
+			t.Errorf("%s: expected SchemaErrors but got %v", e.name, err)
+			continue
+		}
+
+		var got []string
+		for _, v := range schemaErrors {
+			got = append(got, v.InstancePath+":"+v.Keyword)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, e.violations) {
+			t.Errorf("%s: expected violations %v but got %v", e.name, e.violations, got)
+		}
+	}
+}
+
+func TestLoadSchema_Invalid(t *testing.T) {
+	var invalidSchemas = []string{
+		`{"type": 1}`,
+		`{"pattern": "("}`,
+		`{"minLength": -1}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`[]`,
+	}
+
+	for _, s := range invalidSchemas {
+		if _, err := LoadSchema([]byte(s)); err == nil {
+			t.Errorf("expected %s to fail to compile", s)
+		}
+	}
+
+	_, err := LoadSchema([]byte(`{"properties": {"name": {"type": ["null", "strnig"]}}}`))
+	if err == nil || !strings.Contains(err.Error(), "#/properties/name/type") || !strings.Contains(err.Error(), `"strnig"`) {
+		t.Errorf("expected an unknown type name to be rejected with its schema path, got %v", err)
+	}
+}
+
+func TestTools_ReadJSONSchema(t *testing.T) {
+	schema, err := LoadSchema([]byte(`{"type": "object", "properties": {"foo": {"type": "string", "minLength": 3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTools := Tools{JSONSchema: schema}
+
+	var payload struct {
+		Foo string `json:"foo"`
+	}
+
+	request := httptest.NewRequest("POST", "/", strings.NewReader(`{"foo": "ab"}`))
+	err = testTools.ReadJson(httptest.NewRecorder(), request, &payload)
+
+	var schemaErrors SchemaErrors
+	if !errors.As(err, &schemaErrors) || schemaErrors[0].InstancePath != "/foo" {
+		t.Errorf("expected schema violation at /foo, got %v", err)
+	}
+	if payload.Foo != "" {
+		t.Error("body decoded despite failing the schema")
+	}
+
+	request = httptest.NewRequest("POST", "/", strings.NewReader(`{"foo": "abc"}`))
+	if err := testTools.ReadJson(httptest.NewRecorder(), request, &payload); err != nil || payload.Foo != "abc" {
+		t.Errorf("expected valid body to decode, got %q (%v)", payload.Foo, err)
+	}
+}
+
+func TestTools_ReadJSONWithSchema(t *testing.T) {
+	orders, err := LoadSchema([]byte(`{"type": "object", "required": ["qty"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadSchema([]byte(`{"type": "object", "required": ["name"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One Tools serves endpoints with different schemas.
+	testTools := Tools{JSONSchema: users}
+
+	var tests = []struct {
+		name          string
+		schema        *Schema
+		body          string
+		errorExpected bool
+	}{
+		{"own schema", orders, `{"qty": 1}`, false},
+		{"own schema violated", orders, `{"name": "a"}`, true},
+		{"no schema", nil, `{}`, false},
+	}
+
+	for _, e := range tests {
+		var payload map[string]interface{}
+		request := httptest.NewRequest("POST", "/", strings.NewReader(e.body))
+		err := testTools.ReadJSONWithSchema(httptest.NewRecorder(), request, &payload, e.schema)
+		if (err != nil) != e.errorExpected {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+	}
+
+	reader := testTools.NewNDJSONReader(httptest.NewRequest("POST", "/", strings.NewReader("{\"qty\": 1}\n{\"name\": \"a\"}\n")))
+	reader.Schema = orders
+	var record map[string]interface{}
+	if err := reader.Next(&record); err != nil {
+		t.Errorf("expected the first record to match the reader's schema, got %v", err)
+	}
+	if err := reader.Next(&record); err == nil {
+		t.Error("expected the second record to fail the reader's schema")
+	}
+}
@@ -0,0 +1,232 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is one server-sent event. Data is sent as JSON.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// SSEOptions configures ServeSSE.
+type SSEOptions struct {
+	// Heartbeat is how often a comment is sent to keep idle connections
+	// open. It defaults to 15 seconds; a negative value disables it.
+	Heartbeat time.Duration
+	// Retry, when set, tells the browser how long to wait before reconnecting.
+	Retry time.Duration
+	// Replay, when set, is read to send a reconnecting client, identified
+	// by its Last-Event-ID header, the events it missed. ServeSSE does not
+	// add to it: the producer adds each event once and sends the event Add
+	// returns to every connection.
+	Replay *SSEReplayBuffer
+}
+
+// SSEReplayBuffer keeps the most recent events of a stream for clients that
+// reconnect. It is safe for concurrent use and is shared by every connection
+// following the same stream. The producer of the stream adds events to it as
+// they happen, whether or not a client is connected, and ServeSSE only reads
+// from it.
+type SSEReplayBuffer struct {
+	mu     sync.Mutex
+	size   int
+	nextID uint64
+	events []SSEEvent
+}
+
+// NewSSEReplayBuffer returns a buffer holding up to size events. A buffer
+// whose size is zero or negative still numbers events but keeps none, so
+// nothing is replayed.
+func NewSSEReplayBuffer(size int) *SSEReplayBuffer {
+	return &SSEReplayBuffer{size: size}
+}
+
+// Add records event, giving it the next sequential ID if it has none, and
+// returns the event as recorded. Events whose ID is already buffered are not
+// added again.
+func (b *SSEReplayBuffer) Add(event SSEEvent) SSEEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event.ID == "" {
+		b.nextID++
+		event.ID = strconv.FormatUint(b.nextID, 10)
+	}
+	if b.size <= 0 {
+		return event
+	}
+
+	for _, buffered := range b.events {
+		if buffered.ID == event.ID {
+			return event
+		}
+	}
+
+	b.events = append(b.events, event)
+	if len(b.events) > b.size {
+		b.events = b.events[len(b.events)-b.size:]
+	}
+
+	return event
+}
+
+// Since returns the buffered events that followed the event with ID
+// lastEventID. If that event is no longer buffered, every buffered event is
+// returned.
+func (b *SSEReplayBuffer) Since(lastEventID string) []SSEEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, event := range b.events {
+		if event.ID == lastEventID {
+			return append([]SSEEvent(nil), b.events[i+1:]...)
+		}
+	}
+
+	return append([]SSEEvent(nil), b.events...)
+}
+
+// SSEStream writes server-sent events to one client.
+type SSEStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewSSEStream sets the event-stream headers, sends the 200 status and returns
+// a stream for the events that follow. It fails if w cannot be flushed.
+func (t *Tools) NewSSEStream(w http.ResponseWriter) (*SSEStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported by this response writer")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &SSEStream{w: w, flusher: flusher}, nil
+}
+
+// Send writes event and flushes it to the client.
+func (s *SSEStream) Send(event SSEEvent) error {
+	data, err := marshalRedacted(event.Data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + sseField(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + sseField(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	b.WriteString("data: " + string(data) + "\n\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes an SSE comment line, which clients ignore.
+func (s *SSEStream) Comment(text string) error {
+	return s.write(": " + sseField(text) + "\n\n")
+}
+
+func (s *SSEStream) write(text string) error {
+	if _, err := fmt.Fprint(s.w, text); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
+
+// sseField strips line breaks, which would end an SSE field early.
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// ServeSSE streams the events received from events to the client until the
+// channel is closed or the request's context is cancelled, sending heartbeat
+// comments while idle. With a replay buffer, events missed since the client's
+// Last-Event-ID are sent first; events from the channel that were already
+// replayed are skipped.
+func (t *Tools) ServeSSE(w http.ResponseWriter, r *http.Request, events <-chan SSEEvent, opts ...SSEOptions) error {
+	var options SSEOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	stream, err := t.NewSSEStream(w)
+	if err != nil {
+		return err
+	}
+
+	if options.Retry > 0 {
+		if err := stream.write("retry: " + strconv.FormatInt(options.Retry.Milliseconds(), 10) + "\n\n"); err != nil {
+			return err
+		}
+	}
+
+	// An event added to the buffer before Since may also be waiting in the
+	// channel.
+	replayed := map[string]bool{}
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" && options.Replay != nil {
+		for _, event := range options.Replay.Since(lastEventID) {
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			replayed[event.ID] = true
+		}
+	}
+
+	heartbeat := options.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = 15 * time.Second
+	}
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+
+		case <-tick:
+			if err := stream.Comment("heartbeat"); err != nil {
+				return err
+			}
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if event.ID != "" && replayed[event.ID] {
+				delete(replayed, event.ID)
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEStream_Send(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+
+	stream, err := testTools.NewSSEStream(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stream.Send(SSEEvent{ID: "7", Event: "progress", Retry: 3 * time.Second, Data: map[string]int{"percent": 40}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Comment("ping\nstill here"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Type") != "text/event-stream" || rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("wrong headers: %v", rr.Header())
+	}
+	if !rr.Flushed {
+		t.Error("expected the stream to be flushed")
+	}
+
+	expected := "id: 7\nevent: progress\nretry: 3000\ndata: {\"percent\":40}\n\n: pingstill here\n\n"
+	if rr.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rr.Body.String())
+	}
+}
+
+func TestSSEReplayBuffer(t *testing.T) {
+	buffer := NewSSEReplayBuffer(3)
+	for i := 0; i < 5; i++ {
+		buffer.Add(SSEEvent{Data: i})
+	}
+	buffer.Add(SSEEvent{ID: "5", Data: "duplicate"})
+
+	ids := func(events []SSEEvent) string {
+		var list []string
+		for _, event := range events {
+			list = append(list, event.ID)
+		}
+		return strings.Join(list, ",")
+	}
+
+	if got := ids(buffer.Since("3")); got != "4,5" {
+		t.Errorf("expected events after 3 to be 4,5, got %s", got)
+	}
+	if got := ids(buffer.Since("1")); got != "3,4,5" {
+		t.Errorf("expected every buffered event for an evicted ID, got %s", got)
+	}
+	if got := ids(buffer.Since("5")); got != "" {
+		t.Errorf("expected no events after the latest, got %s", got)
+	}
+}
+
+func TestSSEReplayBuffer_NoSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		buffer := NewSSEReplayBuffer(size)
+		for i := 0; i < 3; i++ {
+			if event := buffer.Add(SSEEvent{Data: i}); event.ID != strconv.Itoa(i+1) {
+				t.Errorf("size %d: expected event %d to be numbered, got %q", size, i+1, event.ID)
+			}
+		}
+		if events := buffer.Since("1"); len(events) != 0 {
+			t.Errorf("size %d: expected nothing to be replayed, got %v", size, events)
+		}
+	}
+}
+
+func TestTools_ServeSSE(t *testing.T) {
+	var testTools Tools
+	buffer := NewSSEReplayBuffer(10)
+	buffer.Add(SSEEvent{Event: "progress", Data: 10})
+	events := make(chan SSEEvent, 2)
+	// The producer adds events to the buffer and sends them to the channel,
+	// so an event may arrive both ways.
+	events <- buffer.Add(SSEEvent{Event: "progress", Data: 20})
+	events <- buffer.Add(SSEEvent{Event: "progress", Data: 30})
+	close(events)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+
+	err := testTools.ServeSSE(rr, req, events, SSEOptions{Replay: buffer, Retry: time.Second, Heartbeat: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "retry: 1000\n\n" +
+		"id: 2\nevent: progress\ndata: 20\n\n" +
+		"id: 3\nevent: progress\ndata: 30\n\n"
+	if rr.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rr.Body.String())
+	}
+}
+
+func TestTools_ServeSSESharedReplay(t *testing.T) {
+	var testTools Tools
+	buffer := NewSSEReplayBuffer(10)
+
+	// Two clients follow the stream; the producer adds each event once.
+	first, second := make(chan SSEEvent, 2), make(chan SSEEvent, 2)
+	for _, data := range []int{1, 2} {
+		event := buffer.Add(SSEEvent{Data: data})
+		first <- event
+		second <- event
+	}
+	close(first)
+	close(second)
+
+	for _, events := range []chan SSEEvent{first, second} {
+		if err := testTools.ServeSSE(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil), events, SSEOptions{Replay: buffer, Heartbeat: -1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events produced while no client is connected are still buffered.
+	buffer.Add(SSEEvent{Data: 3})
+
+	if got := len(buffer.Since("")); got != 3 {
+		t.Errorf("expected 3 buffered events, got %d", got)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	closed := make(chan SSEEvent)
+	close(closed)
+	if err := testTools.ServeSSE(rr, req, closed, SSEOptions{Replay: buffer, Heartbeat: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "id: 3\ndata: 3\n\n"; rr.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rr.Body.String())
+	}
+}
+
+func TestTools_ServeSSEHeartbeatAndCancel(t *testing.T) {
+	var testTools Tools
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		done <- testTools.ServeSSE(rr, req, make(chan SSEEvent), SSEOptions{Heartbeat: 10 * time.Millisecond})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeSSE did not return after the request was cancelled")
+	}
+
+	if !strings.Contains(rr.Body.String(), ": heartbeat\n\n") {
+		t.Errorf("expected heartbeat comments, got %q", rr.Body.String())
+	}
+}
+
+type unflushableWriter struct {
+	http.ResponseWriter
+}
+
+func TestTools_NewSSEStreamUnflushable(t *testing.T) {
+	var testTools Tools
+	if _, err := testTools.NewSSEStream(unflushableWriter{httptest.NewRecorder()}); err == nil {
+		t.Error("expected an error for a writer that cannot flush")
+	}
+}
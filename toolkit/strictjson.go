@@ -0,0 +1,242 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONDuplicateKeyError is returned when DisallowDuplicateKeys is set and an
+// object in the body repeats a key.
+type JSONDuplicateKeyError struct {
+	Offset int64
+	Line   int
+	Column int
+	Path   string
+}
+
+func (e *JSONDuplicateKeyError) Error() string {
+	return fmt.Sprintf("body contains duplicate key %q", e.Path)
+}
+
+func (e *JSONDuplicateKeyError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "duplicate_key", Path: e.Path, Offset: e.Offset, Line: e.Line, Column: e.Column}
+}
+
+// JSONDepthError is returned when objects and arrays in the body are nested
+// deeper than MaxJSONDepth.
+type JSONDepthError struct {
+	Offset int64
+	Line   int
+	Column int
+	Path   string
+	Limit  int64
+}
+
+func (e *JSONDepthError) Error() string {
+	return fmt.Sprintf("body must not be nested more than %d levels deep", e.Limit)
+}
+
+func (e *JSONDepthError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "too_deep", Path: e.Path, Offset: e.Offset, Line: e.Line, Column: e.Column, Limit: e.Limit}
+}
+
+// JSONArrayTooLongError is returned when an array in the body has more than
+// MaxJSONArrayLength elements.
+type JSONArrayTooLongError struct {
+	Offset int64
+	Line   int
+	Column int
+	Path   string
+	Limit  int64
+}
+
+func (e *JSONArrayTooLongError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("body must not contain more than %d items", e.Limit)
+	}
+	return fmt.Sprintf("body must not contain more than %d items in %q", e.Limit, e.Path)
+}
+
+func (e *JSONArrayTooLongError) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "array_too_long", Path: e.Path, Offset: e.Offset, Line: e.Line, Column: e.Column, Limit: e.Limit}
+}
+
+// JSONInvalidUTF8Error is returned when RejectInvalidUTF8 is set and the body
+// is not valid UTF-8. Offset is the position of the first invalid byte.
+type JSONInvalidUTF8Error struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+func (e *JSONInvalidUTF8Error) Error() string {
+	return fmt.Sprintf("body contains invalid UTF-8 (at line %d, column %d)", e.Line, e.Column)
+}
+
+func (e *JSONInvalidUTF8Error) ErrorData() interface{} {
+	return JSONErrorDetail{Code: "invalid_utf8", Offset: e.Offset, Line: e.Line, Column: e.Column}
+}
+
+// checkJSONStructure applies the UTF-8, duplicate key, depth and array length
+// settings to body before it is decoded into target. Malformed JSON is left
+// for the decoder to report.
+func (t *Tools) checkJSONStructure(body []byte, target interface{}) error {
+	if t.RejectInvalidUTF8 && !utf8.Valid(body) {
+		offset := 0
+		for offset < len(body) {
+			r, size := utf8.DecodeRune(body[offset:])
+			if r == utf8.RuneError && size <= 1 {
+				break
+			}
+			offset += size
+		}
+		line, column := lineColumn(body, int64(offset)+1)
+		return &JSONInvalidUTF8Error{Offset: int64(offset), Line: line, Column: column}
+	}
+
+	if !t.DisallowDuplicateKeys && t.MaxJSONDepth <= 0 && t.MaxJSONArrayLength <= 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	var walker jsonPathWalker
+	var keys []map[string]bool
+	// types holds the Go type each open object or array decodes into, if
+	// known, and value the type of the next value.
+	var types []reflect.Type
+	value := reflect.TypeOf(target)
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		offset := decoder.InputOffset()
+		line, column := lineColumn(body, offset)
+
+		delim, isDelim := tok.(json.Delim)
+		if isDelim && (delim == '}' || delim == ']') {
+			walker.next(tok)
+			keys, types = keys[:len(keys)-1], types[:len(types)-1]
+			value = nextArrayElem(walker, types)
+			continue
+		}
+
+		path, isKey := walker.next(tok)
+		if isKey {
+			var name string
+			name, value = jsonObjectMember(types[len(types)-1], tok.(string))
+			seen := keys[len(keys)-1]
+			if seen != nil && seen[name] {
+				return &JSONDuplicateKeyError{Offset: offset, Line: line, Column: column, Path: path}
+			}
+			if seen != nil {
+				seen[name] = true
+			}
+			continue
+		}
+
+		parent := len(walker.stack) - 1
+		if isDelim {
+			parent--
+		}
+		if t.MaxJSONArrayLength > 0 && parent >= 0 && walker.stack[parent].array && walker.stack[parent].index >= t.MaxJSONArrayLength {
+			return &JSONArrayTooLongError{
+				Offset: offset,
+				Line:   line,
+				Column: column,
+				Path:   path[:strings.LastIndex(path, "[")],
+				Limit:  int64(t.MaxJSONArrayLength),
+			}
+		}
+
+		if isDelim {
+			if t.MaxJSONDepth > 0 && len(walker.stack) > t.MaxJSONDepth {
+				return &JSONDepthError{Offset: offset, Line: line, Column: column, Path: path, Limit: int64(t.MaxJSONDepth)}
+			}
+
+			var seen map[string]bool
+			if delim == '{' && t.DisallowDuplicateKeys {
+				seen = map[string]bool{}
+			}
+			keys = append(keys, seen)
+			types = append(types, decodedType(value))
+		}
+		value = nextArrayElem(walker, types)
+	}
+}
+
+// decodedType returns the type encoding/json decodes a value of typ into,
+// following pointers, or nil if that depends on the value or the type
+// decodes itself.
+func decodedType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() == reflect.Interface || reflect.PointerTo(typ).Implements(jsonUnmarshalerType) || reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	return typ
+}
+
+// nextArrayElem returns the type of the next value when the innermost open
+// value is an array decoded into a slice or array type.
+func nextArrayElem(walker jsonPathWalker, types []reflect.Type) reflect.Type {
+	if len(types) == 0 || !walker.stack[len(walker.stack)-1].array {
+		return nil
+	}
+	if typ := types[len(types)-1]; typ != nil && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+		return typ.Elem()
+	}
+
+	return nil
+}
+
+// jsonObjectMember returns the name under which key is counted in an object
+// decoded into typ, and the type of its value. encoding/json matches struct
+// fields without regard to case, so keys differing only in case that name
+// the same field are counted as one.
+func jsonObjectMember(typ reflect.Type, key string) (string, reflect.Type) {
+	if typ == nil {
+		return key, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Map:
+		return key, typ.Elem()
+
+	case reflect.Struct:
+		fields := jsonFields(typ)
+		field, ok := fields[key]
+		if !ok {
+			// Like encoding/json, prefer an exact match and otherwise take
+			// the first field, in declaration order, matching without case.
+			for name, candidate := range fields {
+				if candidate.index != nil && strings.EqualFold(name, key) && (!ok || lessIndex(candidate.index, field.index)) {
+					key, field, ok = name, candidate, true
+				}
+			}
+		}
+		if !ok || field.index == nil {
+			return key, nil
+		}
+		return key, typ.FieldByIndex(field.index).Type
+	}
+
+	return key, nil
+}
+
+// lessIndex orders field indexes as the fields are declared.
+func lessIndex(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return len(a) < len(b)
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+var strictJSONTests = []struct {
+	name   string
+	json   string
+	tools  Tools
+	code   string
+	path   string
+	line   int
+	column int
+}{
+	{name: "duplicate key", json: `{"a": 1, "b": {"c": 1, "c": 2}}`, tools: Tools{DisallowDuplicateKeys: true}, code: "duplicate_key", path: "b.c", line: 1, column: 26},
+	{name: "duplicate key in array", json: `[{"a": 1}, {"a": 1, "a": 2}]`, tools: Tools{DisallowDuplicateKeys: true}, code: "duplicate_key", path: "[1].a", line: 1},
+	{name: "duplicate key allowed", json: `{"a": 1, "a": 2}`, tools: Tools{}},
+	{name: "same key in sibling objects", json: `[{"a": 1}, {"a": 2}]`, tools: Tools{DisallowDuplicateKeys: true}},
+	{name: "too deep", json: `{"a": {"b": [[1]]}}`, tools: Tools{MaxJSONDepth: 3}, code: "too_deep", path: "a.b[0]"},
+	{name: "deep enough", json: `{"a": {"b": [1]}}`, tools: Tools{MaxJSONDepth: 3}},
+	{name: "array too long", json: `{"a": [1, 2, 3]}`, tools: Tools{MaxJSONArrayLength: 2}, code: "array_too_long", path: "a"},
+	{name: "nested array too long", json: `[[1], [1, 2, {"x": 1}]]`, tools: Tools{MaxJSONArrayLength: 2}, code: "array_too_long", path: "[1]"},
+	{name: "array short enough", json: `[1, 2]`, tools: Tools{MaxJSONArrayLength: 2}},
+	{name: "invalid utf-8", json: "{\"a\":\n \"b\xffc\"}", tools: Tools{RejectInvalidUTF8: true}, code: "invalid_utf8", line: 2, column: 4},
+	{name: "invalid utf-8 allowed", json: "{\"a\": \"b\xffc\"}", tools: Tools{}},
+	{name: "syntax errors left to decoder", json: `{"a": 1,, "a": 2}`, tools: Tools{DisallowDuplicateKeys: true}, code: "syntax_error"},
+}
+
+func TestTools_ReadJSONStrict(t *testing.T) {
+	for _, e := range strictJSONTests {
+		var payload interface{}
+		request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+		err := e.tools.ReadJson(httptest.NewRecorder(), request, &payload)
+
+		if e.code == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+
+		var provider errorDataProvider
+		if !errors.As(err, &provider) {
+			t.Errorf("%s: expected a typed error, got %v", e.name, err)
+			continue
+		}
+
+		detail := provider.ErrorData().(JSONErrorDetail)
+		if detail.Code != e.code {
+			t.Errorf("%s: expected code %s but got %s", e.name, e.code, detail.Code)
+		}
+		if detail.Path != e.path {
+			t.Errorf("%s: expected path %q but got %q", e.name, e.path, detail.Path)
+		}
+		if e.line != 0 && detail.Line != e.line {
+			t.Errorf("%s: expected line %d but got %d", e.name, e.line, detail.Line)
+		}
+		if e.column != 0 && detail.Column != e.column {
+			t.Errorf("%s: expected column %d but got %d", e.name, e.column, detail.Column)
+		}
+	}
+}
+
+func TestTools_ReadJSONUseNumber(t *testing.T) {
+	testTools := Tools{UseNumber: true}
+	var payload map[string]interface{}
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"id": 9007199254740993}`)))
+	if err := testTools.ReadJson(httptest.NewRecorder(), request, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, ok := payload["id"].(json.Number); !ok || id.String() != "9007199254740993" {
+		t.Errorf("expected the id as an exact json.Number, got %#v", payload["id"])
+	}
+}
+
+func TestTools_ReadJSONDuplicateKeysFoldCase(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	type order struct {
+		Name   string            `json:"name"`
+		Items  []item            `json:"items"`
+		Labels map[string]string `json:"labels"`
+	}
+
+	var tests = []struct {
+		name   string
+		json   string
+		target interface{}
+		path   string
+	}{
+		{"struct", `{"name": "a", "Name": "b"}`, &order{}, "Name"},
+		{"nested struct", `{"items": [{"name": "a"}, {"name": "b", "NAME": "c"}]}`, &order{}, "items[1].NAME"},
+		{"field matched without case", `{"ITEMS": [], "Items": []}`, &order{}, "Items"},
+		{"map keys keep case", `{"labels": {"a": "1", "A": "2"}}`, &order{}, ""},
+		{"untyped keys keep case", `{"name": "a", "Name": "b"}`, &map[string]interface{}{}, ""},
+	}
+
+	testTools := Tools{DisallowDuplicateKeys: true}
+	for _, e := range tests {
+		request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+		err := testTools.ReadJson(httptest.NewRecorder(), request, e.target)
+
+		var duplicate *JSONDuplicateKeyError
+		if e.path == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &duplicate) {
+			t.Errorf("%s: expected a duplicate key error, got %v", e.name, err)
+			continue
+		}
+		if duplicate.Path != e.path {
+			t.Errorf("%s: expected path %q but got %q", e.name, e.path, duplicate.Path)
+		}
+	}
+}
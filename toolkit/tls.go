@@ -0,0 +1,230 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// TLSClientConfig describes the TLS settings of outbound calls. Certificate
+// files are checked for changes at each new connection and reloaded, so that
+// rotated certificates are picked up without a restart.
+type TLSClientConfig struct {
+	// RootCAFiles are PEM files with the certificate authorities trusted to
+	// sign server certificates. They replace the system roots unless
+	// SystemRoots is set; without them the system roots are used.
+	RootCAFiles []string
+	SystemRoots bool
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// MinVersion defaults to tls.VersionTLS12.
+	MinVersion uint16
+	// ServerName overrides the name the server certificate is verified
+	// against, which defaults to the host being called.
+	ServerName string
+}
+
+// NewTLSConfig returns a tls.Config for config. It fails if a certificate
+// file cannot be loaded. With RootCAFiles, servers called by IP address are
+// only accepted if ServerName is set; NewTLSClient has no such restriction.
+func (t *Tools) NewTLSConfig(config TLSClientConfig) (*tls.Config, error) {
+	tlsConfig, _, err := newTLSConfig(config)
+	return tlsConfig, err
+}
+
+func newTLSConfig(config TLSClientConfig) (*tls.Config, *tlsFiles, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, nil, errors.New("tls: CertFile and KeyFile must be set together")
+	}
+
+	files := &tlsFiles{config: config}
+	if err := files.reload(); err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: config.MinVersion,
+		ServerName: config.ServerName,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	if config.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.current()
+			return cert, nil
+		}
+	}
+	if len(config.RootCAFiles) > 0 {
+		// The roots may change between connections, so the server
+		// certificate is verified here rather than with a fixed RootCAs.
+		// The name the client sends in SNI is empty for IP addresses, so
+		// those can only be verified with ServerName or by NewTLSClient.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			name := config.ServerName
+			if name == "" {
+				name = state.ServerName
+			}
+			return files.verify(state, name)
+		}
+	}
+
+	return tlsConfig, files, nil
+}
+
+// NewTLSClient returns an http.Client making its calls with the TLS settings
+// of config, to be used with PushJSONToRemote or as JSONClient.HTTPClient.
+func (t *Tools) NewTLSClient(config TLSClientConfig) (*http.Client, error) {
+	tlsConfig, files, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if tlsConfig.VerifyConnection != nil {
+		// Verify each server against the host dialed, IP addresses included.
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			name := config.ServerName
+			if name == "" {
+				name = host
+			}
+
+			connConfig := transport.TLSClientConfig.Clone()
+			connConfig.ServerName = name
+			connConfig.VerifyConnection = func(state tls.ConnectionState) error {
+				return files.verify(state, name)
+			}
+			dialer := &tls.Dialer{Config: connConfig}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// tlsFiles holds the certificates loaded from a TLSClientConfig's files.
+type tlsFiles struct {
+	config TLSClientConfig
+
+	mu    sync.Mutex
+	stamp string
+	cert  *tls.Certificate
+	roots *x509.CertPool
+}
+
+// current returns the loaded certificate and roots, reloading them if their
+// files have changed. A failed reload, for instance while a file is being
+// rewritten, keeps the previous certificates.
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fileStamp() != f.stamp {
+		_ = f.load()
+	}
+
+	return f.cert, f.roots
+}
+
+func (f *tlsFiles) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.load()
+}
+
+// fileStamp identifies the current version of the files by their sizes and
+// modification times.
+func (f *tlsFiles) fileStamp() string {
+	stamp := ""
+	for _, name := range f.names() {
+		if info, err := os.Stat(name); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return stamp
+}
+
+func (f *tlsFiles) names() []string {
+	names := append([]string(nil), f.config.RootCAFiles...)
+	if f.config.CertFile != "" {
+		names = append(names, f.config.CertFile, f.config.KeyFile)
+	}
+
+	return names
+}
+
+// load reads the files. The caller must hold f.mu.
+func (f *tlsFiles) load() error {
+	stamp := f.fileStamp()
+
+	var cert *tls.Certificate
+	if f.config.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(f.config.CertFile, f.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: loading client certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	var roots *x509.CertPool
+	if len(f.config.RootCAFiles) > 0 {
+		roots = x509.NewCertPool()
+		if f.config.SystemRoots {
+			if system, err := x509.SystemCertPool(); err == nil {
+				roots = system
+			}
+		}
+		for _, name := range f.config.RootCAFiles {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("tls: loading root CAs: %w", err)
+			}
+			if !roots.AppendCertsFromPEM(data) {
+				return fmt.Errorf("tls: no certificates found in %s", name)
+			}
+		}
+	}
+
+	f.stamp, f.cert, f.roots = stamp, cert, roots
+	return nil
+}
+
+// verify checks the server certificate chain against the current roots, and
+// that it is valid for name, a host name or IP address.
+func (f *tlsFiles) verify(state tls.ConnectionState, name string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("tls: server presented no certificate")
+	}
+	if name == "" {
+		return errors.New("tls: no server name to verify the certificate against; set ServerName")
+	}
+	_, roots := f.current()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       name,
+	})
+
+	return err
+}
@@ -0,0 +1,224 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a certificate for name, signed by parent (or
+// self-signed), and its key as PEM files in dir.
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{name},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writeTestPEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func writeTestPEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	modified := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		// Make every rewrite visible, whatever the file system's time
+		// resolution.
+		modified = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTools_NewTLSClient(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "alice", ca, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	writeTestPEM(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", srv.Certificate().Raw)
+
+	var testTools Tools
+	config := TLSClientConfig{
+		RootCAFiles: []string{filepath.Join(dir, "server.crt")},
+		CertFile:    filepath.Join(dir, "client.crt"),
+		KeyFile:     filepath.Join(dir, "client.key"),
+	}
+	if _, err := testTools.NewTLSClient(config); err == nil {
+		t.Fatal("expected an error for a missing client certificate")
+	}
+
+	config.CertFile, config.KeyFile = filepath.Join(dir, "alice.crt"), filepath.Join(dir, "alice.key")
+	client, err := testTools.NewTLSClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := testTools.NewJSONClient(srv.URL)
+	remote.HTTPClient = client
+	get := func() (string, error) {
+		client.CloseIdleConnections()
+		var name string
+		_, err := remote.Get(context.Background(), "/", &name)
+		return name, err
+	}
+
+	if name, err := get(); err != nil || name != "alice" {
+		t.Fatalf("expected to authenticate as alice, got %q %v", name, err)
+	}
+
+	// A rotated certificate is used for the next connection.
+	bob, bobKey := writeTestCert(t, dir, "bob", ca, caKey)
+	keyDER, _ := x509.MarshalECPrivateKey(bobKey)
+	writeTestPEM(t, config.CertFile, "CERTIFICATE", bob.Raw)
+	writeTestPEM(t, config.KeyFile, "EC PRIVATE KEY", keyDER)
+	if name, err := get(); err != nil || name != "bob" {
+		t.Errorf("expected the reloaded certificate for bob, got %q %v", name, err)
+	}
+
+	// So are replaced roots.
+	writeTestPEM(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", ca.Raw)
+	if _, err := get(); err == nil {
+		t.Error("expected the server to be distrusted after replacing the roots")
+	}
+}
+
+func TestTools_NewTLSClient_Versions(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeTestPEM(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", srv.Certificate().Raw)
+
+	var tests = []struct {
+		name       string
+		minVersion uint16
+		expectOK   bool
+	}{
+		{"default", 0, true},
+		{"tls 1.3 only", tls.VersionTLS13, false},
+	}
+
+	var testTools Tools
+	for _, e := range tests {
+		client, err := testTools.NewTLSClient(TLSClientConfig{RootCAFiles: []string{filepath.Join(dir, "server.crt")}, MinVersion: e.minVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := client.Get(srv.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+		if (err == nil) != e.expectOK {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+	}
+
+	if response, err := http.Get(srv.URL); err == nil {
+		response.Body.Close()
+		t.Error("expected the default client not to trust the test server")
+	}
+}
+
+func TestTools_NewTLSClient_ServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "other.example", ca, caKey)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "other.example.crt"), filepath.Join(dir, "other.example.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	var tests = []struct {
+		name       string
+		serverName string
+		expectOK   bool
+	}{
+		{"certificate for another host", "", false},
+		{"matching server name", "other.example", true},
+		{"wrong server name", "example.com", false},
+	}
+
+	var testTools Tools
+	for _, e := range tests {
+		config := TLSClientConfig{RootCAFiles: []string{filepath.Join(dir, "ca.crt")}, ServerName: e.serverName}
+		client, err := testTools.NewTLSClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := client.Get(srv.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+		if (err == nil) != e.expectOK {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+
+		// The bare tls.Config cannot tell which IP address it dialed.
+		tlsConfig, _ := testTools.NewTLSConfig(config)
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), tlsConfig)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != e.expectOK {
+			t.Errorf("%s: unexpected error from NewTLSConfig %v", e.name, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -18,10 +19,28 @@ import (
 const randomStringSource = "abcdefghijklmnoprstuvxyzABCDEFGHIJKLMNOPRSTUVXYZ0123456789_+"
 
 type Tools struct {
-	MaxFileSize        int64
-	AllowedFileTypes   []string
-	MaxJSONSize        int
-	AllowUnknownFields bool
+	MaxFileSize           int64
+	AllowedFileTypes      []string
+	MaxJSONSize           int
+	AllowUnknownFields    bool
+	ValidateJSON          bool
+	ErrorFormat           ErrorFormat
+	MaxNDJSONRecords      int
+	CompressionThreshold  int
+	JSONSchema            *Schema
+	PathParamFunc         func(r *http.Request, name string) string
+	Envelope              Envelope
+	UseNumber             bool
+	DisallowDuplicateKeys bool
+	MaxJSONDepth          int
+	MaxJSONArrayLength    int
+	RejectInvalidUTF8     bool
+	WebhookSigner         *WebhookSigner
+	WebhookVerifier       *WebhookSigner
+	Idempotency           *IdempotencyConfig
+
+	problems []problemRegistration
+	codecs   []Codec
 }
 
 func (tool *Tools) CreateRandomString(number int) string {
@@ -188,81 +207,140 @@ type JSONResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	Meta    interface{} `json:"meta,omitempty"`
 }
 
 func (t *Tools) ReadJson(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1024 * 1024
-	if t.MaxJSONSize != 0 {
-		maxBytes = t.MaxJSONSize
+	body, err := t.readBody(w, r)
+	if err != nil {
+		return err
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	decoder := json.NewDecoder(r.Body)
-
-	if !t.AllowUnknownFields {
-		decoder.DisallowUnknownFields()
-	}
+	return t.decodeJSON(body, data, t.JSONSchema)
+}
 
-	err := decoder.Decode(data)
+// ReadJSONWithSchema reads the request body like ReadJson, but validates it
+// against schema instead of t.JSONSchema, which is only the default for
+// handlers without a schema of their own. A nil schema skips validation.
+func (t *Tools) ReadJSONWithSchema(w http.ResponseWriter, r *http.Request, data interface{}, schema *Schema) error {
+	body, err := t.readBody(w, r)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshallTypeError *json.UnmarshalTypeError
-		var invalidUnmarshallError *json.InvalidUnmarshalError
+		return err
+	}
 
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	return t.decodeJSON(body, data, schema)
+}
 
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("bady contains badly-formed JSON")
+// readBody reads the whole request body, decompressing it according to its
+// Content-Encoding, and fails once it exceeds MaxJSONSize.
+func (t *Tools) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxBytes := 1024 * 1024
+	if t.MaxJSONSize != 0 {
+		maxBytes = t.MaxJSONSize
+	}
 
-		case errors.As(err, &unmarshallTypeError):
-			if unmarshallTypeError.Field != "" {
-				return fmt.Errorf("badly contains incorrect json type for field %q", unmarshallTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect json type (at character %d)", unmarshallTypeError.Offset)
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	body, err := readDecodedBody(r, maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return nil, &JSONTooLargeError{Limit: int64(maxBytes)}
+		}
+		return nil, err
+	}
 
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+	return body, nil
+}
 
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+// decodeJSON decodes a single JSON value from body into data, applying the
+// structure, unknown field and validation settings and, if not nil, schema.
+func (t *Tools) decodeJSON(body []byte, data interface{}, schema *Schema) error {
+	if err := t.checkJSONStructure(body, data); err != nil {
+		return err
+	}
 
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+	if schema != nil {
+		if err := schema.Validate(body); err != nil {
+			return err
+		}
+	}
 
-		case errors.As(err, &invalidUnmarshallError):
-			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
+	decoder := json.NewDecoder(bytes.NewReader(body))
 
-		default:
-			return err
+	if !t.AllowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if t.UseNumber {
+		decoder.UseNumber()
+	}
 
-		}
+	err := decoder.Decode(data)
+	if err != nil {
+		return translateJSONError(err, body, data)
 	}
 
+	offset := decoder.InputOffset()
 	err = decoder.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
+		offset += int64(len(body[offset:]) - len(bytes.TrimLeft(body[offset:], " \t\r\n")))
+		line, column := lineColumn(body, offset+1)
+		return &JSONMultipleValuesError{Offset: offset, Line: line, Column: column}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
 	}
 
 	return nil
 }
 
-func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
-	out, err := json.Marshal(data)
+func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, opts ...WriteOptions) error {
+	return t.writeJSON(w, status, data, "application/json", writeOptions(opts))
+}
+
+func (t *Tools) writeJSON(w http.ResponseWriter, status int, data interface{}, contentType string, opts WriteOptions) error {
+	out, err := t.marshalJSON(data)
 	if err != nil {
 		return err
 	}
 
-	if len(headers) > 0 {
-		for key, value := range headers[0] {
-			w.Header()[key] = value
+	return t.writeBody(w, status, out, contentType, opts)
+}
+
+// writeBody writes an encoded response body, answering conditional requests
+// and compressing the body when the client allows.
+func (t *Tools) writeBody(w http.ResponseWriter, status int, out []byte, contentType string, opts WriteOptions) error {
+	applyHeaders(w, opts)
+
+	// The encoding is chosen first, so that a 304 carries the same ETag and
+	// Vary headers as the full response would.
+	encoding := t.responseEncoding(w, opts.Request, out)
+
+	etag := ""
+	if opts.ETag {
+		etag = strongETag(out)
+		if encoding != "" {
+			w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+		} else {
+			w.Header().Set("ETag", etag)
 		}
 	}
-	w.Header().Set("Content-Type", "application/json")
+
+	if notModified(opts, status, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	if encoding != "" {
+		var err error
+		if out, err = compressResponse(w, out, encoding); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
-	_, err = w.Write(out)
+	_, err := w.Write(out)
 	if err != nil {
 		return err
 	}
@@ -272,46 +350,49 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, h
 
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
+	var coder statusCoder
 	if len(status) > 0 {
 		statusCode = status[0]
+	} else if mapping, ok := t.problemMappingFor(err); ok && mapping.Status != 0 {
+		statusCode = mapping.Status
+	} else if errors.As(err, &coder) && coder.StatusCode() != 0 {
+		statusCode = coder.StatusCode()
+	}
+
+	if t.ErrorFormat == ErrorFormatProblem {
+		return t.writeJSON(w, statusCode, t.problemFor(err, statusCode), problemContentType, WriteOptions{})
 	}
 
 	var payload JSONResponse
 	payload.Error = true
 	payload.Message = err.Error()
 
+	var provider errorDataProvider
+	if errors.As(err, &provider) {
+		payload.Data = provider.ErrorData()
+	}
+
 	return t.WriteJSON(w, statusCode, payload)
 }
 
+// PushJSONToRemote POSTs data as JSON to uri and returns the response, whose
+// body can still be read, and its status code. The request is signed with
+// t.WebhookSigner, if set. For other methods, base URLs, timeouts and decoded
+// replies, use a JSONClient.
 func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
-	//create JSON
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, 0, err
-	}
-
+	remote := t.NewJSONClient("")
+	remote.Signer = t.WebhookSigner
 	//check for custom http.client
-	httpClient := &http.Client{}
 	if len(client) > 0 {
-		httpClient = client[0]
-	}
-
-	//build request and set to header
-	request, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, 0, err
+		remote.HTTPClient = client[0]
 	}
-	request.Header.Set("Content-Type", "application/json")
 
 	//call remote uri
-	response, err := httpClient.Do(request)
+	response, _, err := remote.send(context.Background(), http.MethodPost, uri, data, RequestOptions{})
 	if err != nil {
 		return nil, 0, err
 	}
 
-	defer response.Body.Close()
-
 	//send response back
 	return response, response.StatusCode, nil
-
 }
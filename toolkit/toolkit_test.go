@@ -17,18 +17,6 @@ import (
 	"testing"
 )
 
-type RoundTripFunc func(req *http.Request) *http.Response
-
-func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
-	return f(req), nil
-}
-
-func NewTestClient(fn RoundTripFunc) *http.Client {
-	return &http.Client{
-		Transport: fn,
-	}
-}
-
 func TestTools_PushJSONToRemote(t *testing.T) {
 	client := NewTestClient(func(req *http.Request) *http.Response {
 		return &http.Response{
@@ -307,7 +295,7 @@ func TestTools_WriteJson(t *testing.T) {
 
 	headers := make(http.Header)
 	headers.Add("FOO", "BAR")
-	err := testTools.WriteJSON(responseRecorder, http.StatusOK, payload, headers)
+	err := testTools.WriteJSON(responseRecorder, http.StatusOK, payload, WriteOptions{Headers: headers})
 
 	if err != nil {
 		t.Errorf("Failed to werite json: %v", err)
@@ -0,0 +1,333 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// statusCoder is implemented by errors that know which HTTP status ErrorJSON
+// should use when the caller does not pass one.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// ValidationErrors maps a field path (using JSON names, e.g. items[0].name) to
+// every rule that field failed.
+type ValidationErrors map[string][]string
+
+func (v ValidationErrors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field, strings.Join(v[field], ", ")))
+	}
+
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (v ValidationErrors) ErrorData() interface{} {
+	return map[string][]string(v)
+}
+
+func (v ValidationErrors) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+func (v ValidationErrors) add(field, message string) {
+	v[field] = append(v[field], message)
+}
+
+type validationRule struct {
+	name  string
+	param string
+}
+
+var (
+	validationRuleCache sync.Map
+	validationRegexps   sync.Map
+)
+
+// parseValidationTag splits a validate tag into rules. A regexp rule takes the
+// remainder of the tag so that patterns may contain commas.
+func parseValidationTag(tag string) []validationRule {
+	if cached, ok := validationRuleCache.Load(tag); ok {
+		return cached.([]validationRule)
+	}
+
+	var rules []validationRule
+	rest := tag
+	for rest != "" {
+		var part string
+		if strings.HasPrefix(rest, "regexp=") {
+			part, rest = rest, ""
+		} else if i := strings.IndexByte(rest, ','); i >= 0 {
+			part, rest = rest[:i], rest[i+1:]
+		} else {
+			part, rest = rest, ""
+		}
+
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			rules = append(rules, validationRule{name: name, param: param})
+		}
+	}
+
+	validationRuleCache.Store(tag, rules)
+	return rules
+}
+
+// Validate checks data against the rules in its `validate` struct tags and
+// returns ValidationErrors listing every failure, or nil.
+//
+// Supported rules are required, min, max, len, oneof, email, url and regexp.
+// min, max and len compare numbers by value and strings, slices and maps by
+// length. Nested structs are always validated; dive applies the rules that
+// follow it to each element of a slice or map.
+func (t *Tools) Validate(data interface{}) error {
+	errs := ValidationErrors{}
+	validateValue(reflect.ValueOf(data), "", nil, errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, rules []validationRule, errs ValidationErrors) {
+	for i, rule := range rules {
+		if rule.name == "dive" {
+			applyRules(v, path, rules[:i], errs)
+			diveInto(v, path, rules[i+1:], errs)
+			return
+		}
+	}
+
+	applyRules(v, path, rules, errs)
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Struct || v.Type().Elem().Kind() == reflect.Ptr {
+			diveInto(v, path, nil, errs)
+		}
+	}
+}
+
+func validateStruct(v reflect.Value, path string, errs ValidationErrors) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		fieldPath := name
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			fieldPath = path
+		} else if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		validateValue(v.Field(i), fieldPath, parseValidationTag(field.Tag.Get("validate")), errs)
+	}
+}
+
+func diveInto(v reflect.Value, path string, rules []validationRule, errs ValidationErrors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), rules, errs)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			validateValue(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key.Interface()), rules, errs)
+		}
+	}
+}
+
+// jsonFieldName returns the name encoding/json uses for field.
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+func applyRules(v reflect.Value, path string, rules []validationRule, errs ValidationErrors) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			for _, rule := range rules {
+				if rule.name == "required" {
+					errs.add(path, "is required")
+				}
+			}
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		// format rules only apply to values that are present; pair them with
+		// required to reject empty values
+		switch rule.name {
+		case "oneof", "email", "url", "regexp":
+			if isEmptyValue(v) {
+				continue
+			}
+		}
+
+		if message := checkRule(v, rule); message != "" {
+			errs.add(path, message)
+		}
+	}
+}
+
+func checkRule(v reflect.Value, rule validationRule) string {
+	switch rule.name {
+	case "required":
+		if isEmptyValue(v) {
+			return "is required"
+		}
+
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(rule.param, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid %s rule %q", rule.name, rule.param)
+		}
+		size, isLength := measure(v)
+		unit := ""
+		if isLength {
+			unit = " characters"
+			if v.Kind() != reflect.String {
+				unit = " items"
+			}
+		}
+		switch {
+		case rule.name == "min" && size < limit:
+			return fmt.Sprintf("must be at least %s%s", rule.param, unit)
+		case rule.name == "max" && size > limit:
+			return fmt.Sprintf("must be at most %s%s", rule.param, unit)
+		case rule.name == "len" && size != limit:
+			return fmt.Sprintf("must be exactly %s%s", rule.param, unit)
+		}
+
+	case "oneof":
+		value := fmt.Sprint(v.Interface())
+		options := strings.Fields(rule.param)
+		for _, option := range options {
+			if value == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(options, ", "))
+
+	case "email":
+		address, err := mail.ParseAddress(v.String())
+		if err != nil || address.Address != v.String() {
+			return "must be a valid email address"
+		}
+
+	case "url":
+		u, err := url.ParseRequestURI(v.String())
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be a valid URL"
+		}
+
+	case "regexp":
+		regex, err := compileValidationRegexp(rule.param)
+		if err != nil {
+			return fmt.Sprintf("has invalid regexp rule %q", rule.param)
+		}
+		if !regex.MatchString(fmt.Sprint(v.Interface())) {
+			return fmt.Sprintf("must match %s", rule.param)
+		}
+
+	default:
+		return fmt.Sprintf("has unknown validation rule %q", rule.name)
+	}
+
+	return ""
+}
+
+func compileValidationRegexp(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := validationRegexps.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	validationRegexps.Store(pattern, regex)
+
+	return regex, nil
+}
+
+// measure returns the value that min, max and len compare against, and whether
+// it is a length rather than a number.
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+
+	return 0, false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+
+	return v.IsZero()
+}
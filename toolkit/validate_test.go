@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type validateTestAddress struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"len=5,regexp=^[0-9]+$"`
+}
+
+type validateTestUser struct {
+	Name      string                `json:"name" validate:"required,min=2,max=10"`
+	Email     string                `json:"email" validate:"required,email"`
+	Website   string                `json:"website" validate:"url"`
+	Age       int                   `json:"age" validate:"min=18,max=130"`
+	Role      string                `json:"role" validate:"oneof=admin user"`
+	Tags      []string              `json:"tags" validate:"max=3,dive,min=2"`
+	Addresses []validateTestAddress `json:"addresses"`
+	Manager   *validateTestAddress  `json:"manager"`
+}
+
+var validateTests = []struct {
+	name   string
+	json   string
+	errors map[string]int
+}{
+	{name: "valid", json: `{"name": "Jack", "email": "jack@example.com", "age": 30, "role": "admin",
+		"tags": ["go", "json"], "addresses": [{"city": "Istanbul", "zip": "34000"}]}`, errors: map[string]int{}},
+	{name: "missing required", json: `{"age": 30}`, errors: map[string]int{"name": 2, "email": 1}},
+	{name: "formats", json: `{"name": "Jack", "email": "not-an-email", "website": "example", "age": 30, "role": "root"}`,
+		errors: map[string]int{"email": 1, "website": 1, "role": 1}},
+	{name: "numbers", json: `{"name": "Jack", "email": "jack@example.com", "age": 7}`, errors: map[string]int{"age": 1}},
+	{name: "dive", json: `{"name": "Jack", "email": "jack@example.com", "age": 30, "tags": ["go", "x", "y", "z"]}`,
+		errors: map[string]int{"tags": 1, "tags[1]": 1, "tags[2]": 1, "tags[3]": 1}},
+	{name: "nested", json: `{"name": "Jack", "email": "jack@example.com", "age": 30,
+		"addresses": [{"city": "Istanbul", "zip": "34000"}, {"zip": "3400a"}], "manager": {"city": "Izmir", "zip": "1"}}`,
+		errors: map[string]int{"addresses[1].city": 1, "addresses[1].zip": 1, "manager.zip": 1}},
+}
+
+func TestTools_Validate(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validateTests {
+		var user validateTestUser
+		if err := json.Unmarshal([]byte(e.json), &user); err != nil {
+			t.Fatalf("%s: bad test json: %s", e.name, err)
+		}
+
+		err := testTools.Validate(&user)
+		if len(e.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			}
+			continue
+		}
+
+		var validationErrors ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			t.Errorf("%s: expected ValidationErrors but got %v", e.name, err)
+			continue
+		}
+
+		if len(validationErrors) != len(e.errors) {
+			t.Errorf("%s: expected %d failing fields but got %v", e.name, len(e.errors), validationErrors)
+		}
+		for field, count := range e.errors {
+			if len(validationErrors[field]) != count {
+				t.Errorf("%s: expected %d errors for %s but got %v", e.name, count, field, validationErrors[field])
+			}
+		}
+	}
+}
+
+func TestTools_ReadJSONValidates(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+	var user validateTestUser
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name": "J", "age": 30}`)))
+	err := testTools.ReadJson(httptest.NewRecorder(), request, &user)
+	if err == nil {
+		t.Fatal("expected validation error but none received")
+	}
+
+	responseRecorder := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(responseRecorder, err)
+
+	if responseRecorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code returned; expected 422 but got %d", responseRecorder.Code)
+	}
+
+	var payload struct {
+		Error bool                `json:"error"`
+		Data  map[string][]string `json:"data"`
+	}
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&payload); err != nil {
+		t.Fatal("received error when decoding JSON", err)
+	}
+
+	if len(payload.Data["name"]) != 1 || len(payload.Data["email"]) != 1 {
+		t.Errorf("unexpected field errors: %v", payload.Data)
+	}
+}
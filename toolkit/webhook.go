@@ -0,0 +1,273 @@
+package toolkit
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NonceCache remembers the nonces of webhooks already accepted, so that a
+// captured request cannot be replayed.
+type NonceCache interface {
+	// Seen records nonce until expires and reports whether it had already
+	// been recorded. It must be safe for concurrent use.
+	Seen(nonce string, expires time.Time) bool
+}
+
+// memoryNonceCache keeps nonces in a map, and in a heap ordered by expiry so
+// that expired nonces are dropped without scanning the map.
+type memoryNonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	expiry expiryHeap
+}
+
+func (c *memoryNonceCache) Seen(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for len(c.expiry) > 0 && now.After(c.expiry[0].expires) {
+		expired := heap.Pop(&c.expiry).(keyExpiry)
+		if c.nonces[expired.key].Equal(expired.expires) {
+			delete(c.nonces, expired.key)
+		}
+	}
+
+	if _, ok := c.nonces[nonce]; ok {
+		return true
+	}
+	c.nonces[nonce] = expires
+	heap.Push(&c.expiry, keyExpiry{key: nonce, expires: expires})
+
+	return false
+}
+
+// keyExpiry is when a cached key expires.
+type keyExpiry struct {
+	key     string
+	expires time.Time
+}
+
+// expiryHeap implements heap.Interface, earliest expiry first.
+type expiryHeap []keyExpiry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(keyExpiry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// WebhookSigner signs outbound webhooks or verifies inbound ones with
+// HMAC-SHA256 over the timestamp, nonce and body:
+//
+//	signature = hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + body))
+//
+// sent as "sha256=<signature>". With SignRequestTarget, the method, host and
+// path are signed too:
+//
+//	signature = hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" +
+//		method + "\n" + host + "\n" + path + "\n" + body))
+//
+// where path includes the query. Set one as Tools.WebhookSigner to sign the
+// requests of PushJSONToRemote, or as JSONClient.Signer to sign a client's
+// requests, and another, with a different secret, as Tools.WebhookVerifier
+// to verify requests with the VerifyWebhook middleware. Sharing a secret
+// between both directions would let requests we send be replayed to us.
+type WebhookSigner struct {
+	Secret []byte
+	// The header names default to X-Webhook-Signature, X-Webhook-Timestamp
+	// and X-Webhook-Nonce.
+	SignatureHeader string
+	TimestampHeader string
+	NonceHeader     string
+	// MaxSkew is how far a webhook's timestamp may be from the current time.
+	// It defaults to 5 minutes.
+	MaxSkew time.Duration
+	// Nonces defaults to an in-memory cache.
+	Nonces NonceCache
+	// SignRequestTarget binds signatures to the method, host and path of
+	// the request, so that a webhook cannot be replayed to another endpoint
+	// sharing the secret. Both sides must set it, and the verifier must see
+	// the host and path the sender used, which proxies rewriting either
+	// break.
+	SignRequestTarget bool
+
+	once sync.Once
+}
+
+// WebhookVerificationError is returned, and sent with status 401, when an
+// inbound webhook fails verification.
+type WebhookVerificationError struct {
+	Reason string
+}
+
+func (e *WebhookVerificationError) Error() string {
+	return "webhook verification failed: " + e.Reason
+}
+
+func (e *WebhookVerificationError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+func (s *WebhookSigner) headers() (signature, timestamp, nonce string) {
+	signature, timestamp, nonce = s.SignatureHeader, s.TimestampHeader, s.NonceHeader
+	if signature == "" {
+		signature = "X-Webhook-Signature"
+	}
+	if timestamp == "" {
+		timestamp = "X-Webhook-Timestamp"
+	}
+	if nonce == "" {
+		nonce = "X-Webhook-Nonce"
+	}
+
+	return signature, timestamp, nonce
+}
+
+func (s *WebhookSigner) maxSkew() time.Duration {
+	if s.MaxSkew == 0 {
+		return 5 * time.Minute
+	}
+
+	return s.MaxSkew
+}
+
+// errNoWebhookSecret is returned by a WebhookSigner without a Secret.
+var errNoWebhookSecret = errors.New("webhook signer has no secret")
+
+func (s *WebhookSigner) signature(timestamp, nonce, method, host, path string, body []byte) string {
+	fields := []string{timestamp, nonce}
+	if s.SignRequestTarget {
+		fields = append(fields, strings.ToUpper(method), strings.ToLower(host), path)
+	}
+
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(strings.Join(fields, "\n") + "\n"))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds the timestamp, nonce and signature headers for body to request.
+func (s *WebhookSigner) Sign(request *http.Request, body []byte) error {
+	if len(s.Secret) == 0 {
+		return errNoWebhookSecret
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(random)
+	signatureHeader, timestampHeader, nonceHeader := s.headers()
+	request.Header.Set(timestampHeader, timestamp)
+	request.Header.Set(nonceHeader, nonce)
+	request.Header.Set(signatureHeader, s.signature(timestamp, nonce, request.Method, host, request.URL.RequestURI(), body))
+
+	return nil
+}
+
+// Verify checks the signature, timestamp and nonce of a request whose body
+// is body.
+func (s *WebhookSigner) Verify(r *http.Request, body []byte) error {
+	if len(s.Secret) == 0 {
+		return errNoWebhookSecret
+	}
+
+	signatureHeader, timestampHeader, nonceHeader := s.headers()
+	signature, timestamp, nonce := r.Header.Get(signatureHeader), r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return &WebhookVerificationError{Reason: fmt.Sprintf("%s, %s and %s headers are required", signatureHeader, timestampHeader, nonceHeader)}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &WebhookVerificationError{Reason: "timestamp is not a unix time"}
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.maxSkew() {
+		return &WebhookVerificationError{Reason: "timestamp is too far from the current time"}
+	}
+
+	// RequestURI is the path as received, before any router rewrote r.URL.
+	path := r.RequestURI
+	if path == "" {
+		path = r.URL.RequestURI()
+	}
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(s.signature(timestamp, nonce, r.Method, r.Host, path, body))) {
+		return &WebhookVerificationError{Reason: "signature does not match"}
+	}
+
+	s.once.Do(func() {
+		if s.Nonces == nil {
+			s.Nonces = &memoryNonceCache{nonces: map[string]time.Time{}}
+		}
+	})
+	// Timestamps outside the skew are rejected, so nonces need only be kept
+	// until then.
+	if s.Nonces.Seen(nonce, time.Unix(seconds, 0).Add(s.maxSkew())) {
+		return &WebhookVerificationError{Reason: "nonce has already been used"}
+	}
+
+	return nil
+}
+
+// VerifyWebhook is middleware that rejects requests not signed with
+// t.WebhookVerifier, answering them with ErrorJSON, before next can read the
+// body. The body is limited to MaxJSONSize and left for next to read.
+func (t *Tools) VerifyWebhook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.WebhookVerifier == nil || len(t.WebhookVerifier.Secret) == 0 {
+			_ = t.ErrorJSON(w, errors.New("webhook verification is not configured"), http.StatusInternalServerError)
+			return
+		}
+
+		maxBytes := 1024 * 1024
+		if t.MaxJSONSize != 0 {
+			maxBytes = t.MaxJSONSize
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = &JSONTooLargeError{Limit: int64(maxBytes)}
+			}
+			_ = t.ErrorJSON(w, err)
+			return
+		}
+
+		if err := t.WebhookVerifier.Verify(r, body); err != nil {
+			_ = t.ErrorJSON(w, err)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
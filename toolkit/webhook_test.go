@@ -0,0 +1,181 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTools_SignedWebhookRoundTrip(t *testing.T) {
+	signer := &WebhookSigner{Secret: []byte("s3cret"), SignatureHeader: "X-Sig"}
+	receiver := Tools{WebhookVerifier: signer}
+
+	var received clientTestItem
+	srv := httptest.NewServer(receiver.VerifyWebhook(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := receiver.ReadJson(w, r, &received); err != nil {
+			_ = receiver.ErrorJSON(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer srv.Close()
+
+	sender := Tools{WebhookSigner: &WebhookSigner{Secret: []byte("s3cret"), SignatureHeader: "X-Sig"}}
+	_, status, err := sender.PushJSONToRemote(srv.URL, clientTestItem{ID: 7, Name: "event"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusNoContent || received.ID != 7 {
+		t.Errorf("expected the signed webhook to be accepted, got %d %+v", status, received)
+	}
+
+	wrong := Tools{WebhookSigner: &WebhookSigner{Secret: []byte("wrong"), SignatureHeader: "X-Sig"}}
+	if _, status, _ := wrong.PushJSONToRemote(srv.URL, clientTestItem{ID: 8}); status != http.StatusUnauthorized {
+		t.Errorf("expected a wrongly signed webhook to be rejected, got %d", status)
+	}
+}
+
+func TestTools_VerifyWebhook(t *testing.T) {
+	signer := &WebhookSigner{Secret: []byte("s3cret"), MaxSkew: time.Minute, SignRequestTarget: true}
+	testTools := Tools{WebhookVerifier: signer}
+	handler := testTools.VerifyWebhook(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	body := []byte(`{"id":1}`)
+	signed := func() *http.Request {
+		req := httptest.NewRequest("POST", "/hook", bytes.NewReader(body))
+		if err := signer.Sign(req, body); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	valid := signed()
+	replayed := valid.Clone(valid.Context())
+	replayed.Body = io.NopCloser(bytes.NewReader(body))
+
+	tampered := signed()
+	tampered.Body = io.NopCloser(bytes.NewReader([]byte(`{"id":2}`)))
+
+	stale := httptest.NewRequest("POST", "/hook", bytes.NewReader(body))
+	timestamp := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	stale.Header.Set("X-Webhook-Timestamp", timestamp)
+	stale.Header.Set("X-Webhook-Nonce", "n1")
+	stale.Header.Set("X-Webhook-Signature", signer.signature(timestamp, "n1", "POST", "example.com", "/hook", body))
+
+	unsigned := httptest.NewRequest("POST", "/hook", bytes.NewReader(body))
+
+	// A request signed for another endpoint cannot be replayed to this one.
+	elsewhere := httptest.NewRequest("POST", "/other", bytes.NewReader(body))
+	if err := signer.Sign(elsewhere, body); err != nil {
+		t.Fatal(err)
+	}
+	redirected := httptest.NewRequest("POST", "/hook", bytes.NewReader(body))
+	redirected.Header = elsewhere.Header
+
+	var webhookTests = []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{name: "valid", request: valid, status: http.StatusOK},
+		{name: "replayed", request: replayed, status: http.StatusUnauthorized},
+		{name: "tampered", request: tampered, status: http.StatusUnauthorized},
+		{name: "stale", request: stale, status: http.StatusUnauthorized},
+		{name: "unsigned", request: unsigned, status: http.StatusUnauthorized},
+		{name: "other path", request: redirected, status: http.StatusUnauthorized},
+	}
+
+	for _, e := range webhookTests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, e.request)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d: %s", e.name, e.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestWebhookSigner_RequestTarget(t *testing.T) {
+	body := []byte(`{"id":1}`)
+
+	var tests = []struct {
+		name           string
+		signTarget     bool
+		expectVerified bool
+	}{
+		{"default", false, true},
+		{"request target signed", true, false},
+	}
+
+	for _, e := range tests {
+		sender := &WebhookSigner{Secret: []byte("s3cret"), SignRequestTarget: e.signTarget}
+		verifier := &WebhookSigner{Secret: []byte("s3cret"), SignRequestTarget: e.signTarget}
+
+		sent, err := http.NewRequest("POST", "https://hooks.example.com/public/hook", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sender.Sign(sent, body); err != nil {
+			t.Fatal(err)
+		}
+
+		// A proxy forwards the webhook to another host, without the prefix.
+		received := httptest.NewRequest("POST", "http://10.0.0.5:8080/hook", bytes.NewReader(body))
+		received.Header = sent.Header
+		if err := verifier.Verify(received, body); (err == nil) != e.expectVerified {
+			t.Errorf("%s: unexpected verification result %v", e.name, err)
+		}
+	}
+}
+
+func TestWebhookSigner_Configuration(t *testing.T) {
+	var seen http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	testTools := Tools{WebhookSigner: &WebhookSigner{Secret: []byte("s3cret")}}
+	if _, err := testTools.NewJSONClient(srv.URL).Post(context.Background(), "/", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Get("X-Webhook-Signature") != "" {
+		t.Error("expected JSONClient requests not to be signed unless asked")
+	}
+
+	testTools.WebhookSigner.Secret = nil
+	if _, _, err := testTools.PushJSONToRemote(srv.URL, 1); err == nil {
+		t.Error("expected an error signing without a secret")
+	}
+
+	empty := Tools{WebhookVerifier: &WebhookSigner{}}
+	rr := httptest.NewRecorder()
+	empty.VerifyWebhook(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, httptest.NewRequest("POST", "/hook", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected a verifier without a secret to be refused, got %d", rr.Code)
+	}
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	cache := &memoryNonceCache{nonces: map[string]time.Time{}}
+	now := time.Now()
+
+	if cache.Seen("a", now.Add(-time.Second)) || cache.Seen("b", now.Add(time.Hour)) {
+		t.Fatal("expected new nonces not to be seen")
+	}
+	if !cache.Seen("b", now.Add(time.Hour)) {
+		t.Error("expected a repeated nonce to be seen")
+	}
+	if cache.Seen("a", now.Add(time.Hour)) {
+		t.Error("expected an expired nonce to be forgotten")
+	}
+	if len(cache.nonces) != 2 || len(cache.expiry) != 2 {
+		t.Errorf("expected expired nonces to be dropped, got %d and %d", len(cache.nonces), len(cache.expiry))
+	}
+}